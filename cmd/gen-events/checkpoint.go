@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/melan/gen-events/events_generator"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type checkpoint struct {
+	RunId     string               `json:"run_id"`
+	StartedAt time.Time            `json:"started_at"`
+	SavedAt   time.Time            `json:"saved_at"`
+	Orgs      []pipelineCheckpoint `json:"orgs"`
+}
+
+type pipelineCheckpoint struct {
+	events_generator.OrgCheckpoint
+	Paused      bool    `json:"paused"`
+	IntervalSec float64 `json:"interval_sec"`
+}
+
+// checkpoint captures state of all orgs and their pipelines
+func (f *fleet) checkpoint() (checkpoint, error) {
+	pumps := f.pipelines()
+	cp := checkpoint{
+		RunId:     f.cfg.runId,
+		StartedAt: f.cfg.startedAt,
+		SavedAt:   time.Now().UTC(),
+		Orgs:      make([]pipelineCheckpoint, 0, len(pumps)),
+	}
+
+	for _, pump := range pumps {
+		orgCheckpoint, err := pump.Org().Checkpoint()
+		if err != nil {
+			return checkpoint{}, err
+		}
+
+		status := pump.Status()
+		cp.Orgs = append(cp.Orgs, pipelineCheckpoint{
+			OrgCheckpoint: orgCheckpoint,
+			Paused:        status.Paused,
+			IntervalSec:   status.IntervalSec,
+		})
+	}
+
+	return cp, nil
+}
+
+// restore recreates orgs from the checkpoint. Pipeline settings are applied by resume once orgs are started
+func (f *fleet) restore(cp checkpoint) ([]*events_generator.Org, error) {
+	orgs := make([]*events_generator.Org, 0, len(cp.Orgs))
+	for _, orgCheckpoint := range cp.Orgs {
+		org, err := events_generator.RestoreOrg(orgCheckpoint.OrgCheckpoint, f.cfg.debugEvents, f.cfg.prefix)
+		if err != nil {
+			return nil, err
+		}
+		f.setupOrg(org)
+		orgs = append(orgs, org)
+	}
+
+	return orgs, nil
+}
+
+// resume pauses pipelines and sets intervals the way they were when the checkpoint was taken
+func (f *fleet) resume(cp checkpoint) {
+	for _, orgCheckpoint := range cp.Orgs {
+		pump, err := f.pipeline(orgCheckpoint.CaseId, orgCheckpoint.OrgId)
+		if err != nil {
+			continue
+		}
+
+		if orgCheckpoint.IntervalSec > 0 {
+			pump.SetInterval(time.Duration(orgCheckpoint.IntervalSec * float64(time.Second)))
+		}
+		if orgCheckpoint.Paused {
+			pump.Pause()
+		}
+	}
+}
+
+// saveCheckpoint writes the checkpoint next to the file and moves it in place, so a crash doesn't leave a partial one
+func (f *fleet) saveCheckpoint(path string) error {
+	cp, err := f.checkpoint()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "can't create checkpoint %s", tmp)
+	}
+
+	if err := json.NewEncoder(file).Encode(cp); err != nil {
+		file.Close()
+		return errors.Wrapf(err, "can't write checkpoint %s", tmp)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return errors.Wrapf(err, "can't write checkpoint %s", tmp)
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, "can't write checkpoint %s", tmp)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrapf(err, "can't move checkpoint to %s", path)
+	}
+
+	log.Infof("saved state of %d orgs to %s", len(cp.Orgs), path)
+	return nil
+}
+
+func (f *fleet) saveCheckpointPeriodically(ctx context.Context, path string, interval time.Duration, g *sync.WaitGroup) {
+	defer g.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.saveCheckpoint(path); err != nil {
+				log.WithError(err).Error("can't save checkpoint")
+			}
+		}
+	}
+}
+
+// readCheckpoint returns false if there is no checkpoint yet
+func readCheckpoint(path string) (checkpoint, bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return checkpoint{}, false, nil
+	}
+	if err != nil {
+		return checkpoint{}, false, errors.Wrapf(err, "can't open checkpoint %s", path)
+	}
+	defer file.Close()
+
+	var cp checkpoint
+	if err := json.NewDecoder(file).Decode(&cp); err != nil {
+		return checkpoint{}, false, errors.Wrapf(err, "can't read checkpoint %s", path)
+	}
+
+	return cp, true, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/melan/gen-events/output"
+	log "github.com/sirupsen/logrus"
+)
+
+// kinesis allows to delete up to 5 streams per second
+const cleanupWorkers = 5
+
+func cleanupStreams(cfg config) {
+	if cfg.manifestPath != "" {
+		cleanupManifest(cfg)
+		return
+	}
+
+	if cfg.output != KinesisOutput && cfg.output != A8mKinesisOutput {
+		log.Fatalf("cleanup of %s output isn't supported", cfg.output)
+	}
+
+	if cfg.cleanupPrefix == "" && len(cfg.cleanupTags) == 0 {
+		log.Fatal("refusing to remove all streams: either --prefix or --match-tag has to be set")
+	}
+
+	client := newKinesisClient()
+	streams, err := output.ListStreams(client, cfg.cleanupPrefix, cfg.cleanupTags)
+	if err != nil {
+		log.WithError(err).Fatal("can't find streams to remove")
+	}
+
+	log.Infof("found %d streams with prefix %q and tags %v", len(streams), cfg.cleanupPrefix, cfg.cleanupTags)
+	if cfg.dryRun {
+		for _, stream := range streams {
+			log.Infof("stream %s would be removed", stream)
+		}
+		return
+	}
+
+	queue := make(chan string)
+	g := &sync.WaitGroup{}
+	failed := false
+	lock := sync.Mutex{}
+	for i := 0; i < cleanupWorkers; i++ {
+		g.Add(1)
+		go func() {
+			defer g.Done()
+			for stream := range queue {
+				log.Infof("removing stream %s", stream)
+				if err := output.DeleteStream(client, stream); err != nil {
+					log.WithError(err).Errorf("can't remove stream %s", stream)
+					lock.Lock()
+					failed = true
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, stream := range streams {
+		queue <- stream
+	}
+	close(queue)
+	g.Wait()
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// cleanupManifest removes all resources which are still alive according to the manifest
+func cleanupManifest(cfg config) {
+	resources, err := output.ReadManifest(cfg.manifestPath)
+	if err != nil {
+		log.WithError(err).Fatal("can't read manifest")
+	}
+
+	log.Infof("found %d resources in manifest %s", len(resources), cfg.manifestPath)
+	if cfg.dryRun {
+		for _, resource := range resources {
+			log.Infof("%s %s would be removed", resource.Type, resource.Name)
+		}
+		return
+	}
+
+	manifest, err := output.OpenManifest(cfg.manifestPath, cfg.runId)
+	if err != nil {
+		log.WithError(err).Fatal("can't open manifest")
+	}
+	defer manifest.Close()
+
+	clients := make(map[string]*kinesis.Kinesis)
+	failed := false
+	for _, resource := range resources {
+		log.Infof("removing %s %s", resource.Type, resource.Name)
+
+		var err error
+		switch resource.Type {
+		case output.KinesisStreamResource:
+			clientKey := resource.Region + " " + resource.Endpoint
+			client, ok := clients[clientKey]
+			if !ok {
+				client = newKinesisClientFor(resource.Region, resource.Endpoint)
+				clients[clientKey] = client
+			}
+			err = output.DeleteStream(client, resource.Name)
+		case output.FileResource:
+			err = os.Remove(filepath.Join(resource.Endpoint, resource.Name))
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		default:
+			err = fmt.Errorf("unknown type of resource %s", resource.Type)
+		}
+
+		if err != nil {
+			log.WithError(err).Errorf("can't remove %s %s", resource.Type, resource.Name)
+			failed = true
+			continue
+		}
+
+		if err := manifest.Removed(resource); err != nil {
+			log.WithError(err).Errorf("can't record removal of %s in manifest", resource.Name)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func parseTagPairs(pairs []string) map[string]string {
+	tags := make(map[string]string, len(pairs))
+	for _, tagPair := range pairs {
+		split := strings.SplitN(tagPair, "=", 2)
+		if len(split) != 2 {
+			log.Infof("can't parse tag %s. Skipping", tagPair)
+			continue
+		}
+
+		tags[split[0]] = split[1]
+	}
+
+	return tags
+}
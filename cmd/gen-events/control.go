@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/melan/gen-events/events_generator"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type addOrgRequest struct {
+	Case    events_generator.Case    `json:"case"`
+	OrgId   string                   `json:"org_id"`
+	OrgSize events_generator.OrgSize `json:"org_size"`
+}
+
+type intervalRequest struct {
+	IntervalSec float64 `json:"interval_sec"`
+}
+
+// registerControlApi adds endpoints to manage orgs and pipelines of the fleet:
+//
+//	GET /orgs, POST /orgs, GET|DELETE /orgs/{case}/{org}, POST /orgs/{case}/{org}/pause|resume,
+//	PUT /orgs/{case}/{org}/interval and PUT /interval
+func registerControlApi(mux *http.ServeMux, f *fleet) {
+	mux.HandleFunc("/orgs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJson(w, http.StatusOK, f.list())
+		case http.MethodPost:
+			var req addOrgRequest
+			if err := readJson(r, &req); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			if err := validateOrgRequest(req); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			status, err := f.add(req.Case, req.OrgId, req.OrgSize)
+			if err != nil {
+				writeFleetError(w, err)
+				return
+			}
+			writeJson(w, http.StatusCreated, status)
+		default:
+			writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	})
+
+	mux.HandleFunc("/orgs/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/orgs/"), "/"), "/")
+		if len(parts) < 2 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("path has to be /orgs/{case}/{org}"))
+			return
+		}
+		caseId, orgId := events_generator.Case(parts[0]), parts[1]
+		action := strings.Join(parts[2:], "/")
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			pump, err := f.pipeline(caseId, orgId)
+			if err != nil {
+				writeFleetError(w, err)
+				return
+			}
+			writeJson(w, http.StatusOK, pump.Status())
+		case action == "" && r.Method == http.MethodDelete:
+			if err := f.remove(caseId, orgId); err != nil {
+				writeFleetError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case action == "":
+			writeMethodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		case (action == "pause" || action == "resume") && r.Method == http.MethodPost:
+			pump, err := f.pipeline(caseId, orgId)
+			if err != nil {
+				writeFleetError(w, err)
+				return
+			}
+			if action == "pause" {
+				pump.Pause()
+			} else {
+				pump.Resume()
+			}
+			log.Infof("%sd pipeline of org %s of case %s", action, orgId, caseId)
+			writeJson(w, http.StatusOK, pump.Status())
+		case action == "pause" || action == "resume":
+			writeMethodNotAllowed(w, http.MethodPost)
+		case action == "interval" && r.Method == http.MethodPut:
+			interval, err := readInterval(r)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			pump, err := f.pipeline(caseId, orgId)
+			if err != nil {
+				writeFleetError(w, err)
+				return
+			}
+			pump.SetInterval(interval)
+			log.Infof("interval of org %s of case %s is %s now", orgId, caseId, interval)
+			writeJson(w, http.StatusOK, pump.Status())
+		case action == "interval":
+			writeMethodNotAllowed(w, http.MethodPut)
+		default:
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", action))
+		}
+	})
+
+	mux.HandleFunc("/interval", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			writeMethodNotAllowed(w, http.MethodPut)
+			return
+		}
+
+		interval, err := readInterval(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		f.setInterval(interval)
+		log.Infof("interval of all orgs is %s now", interval)
+		writeJson(w, http.StatusOK, f.list())
+	})
+}
+
+func validateOrgRequest(req addOrgRequest) error {
+	switch req.Case {
+	case events_generator.CaseOne, events_generator.CaseTwo, events_generator.CaseThree,
+		events_generator.CaseFour, events_generator.CaseFive:
+	default:
+		return fmt.Errorf("unknown case %q", req.Case)
+	}
+
+	switch req.OrgSize {
+	case "", events_generator.TinyOrg, events_generator.SmallOrg, events_generator.MediumOrg, events_generator.LargeOrg:
+	default:
+		return fmt.Errorf("unknown org size %q", req.OrgSize)
+	}
+
+	if strings.Contains(req.OrgId, "/") {
+		return fmt.Errorf("org id %q can't contain '/'", req.OrgId)
+	}
+
+	return nil
+}
+
+func readInterval(r *http.Request) (time.Duration, error) {
+	var req intervalRequest
+	if err := readJson(r, &req); err != nil {
+		return 0, err
+	}
+	if req.IntervalSec <= 0 {
+		return 0, fmt.Errorf("interval_sec has to be positive, got %v", req.IntervalSec)
+	}
+
+	return time.Duration(req.IntervalSec * float64(time.Second)), nil
+}
+
+func readJson(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("can't parse request body: %s", err)
+	}
+
+	return nil
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithError(err).Debug("can't write response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeFleetError(w http.ResponseWriter, err error) {
+	switch errors.Cause(err) {
+	case errUnknownOrg:
+		writeError(w, http.StatusNotFound, err)
+	case errDuplicateOrg:
+		writeError(w, http.StatusConflict, err)
+	case errInvalidOrg:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("only %s allowed", strings.Join(methods, ", ")))
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/melan/gen-events/events_generator"
+	"github.com/melan/gen-events/output"
+	"github.com/melan/gen-events/pipeline"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errUnknownOrg   = errors.New("unknown org")
+	errDuplicateOrg = errors.New("org already exists")
+	errInvalidOrg   = errors.New("invalid org")
+)
+
+// fleet runs a pipeline per org and lets orgs be added, removed, paused and resumed while the generator is running
+type fleet struct {
+	cfg           config
+	ctx           context.Context
+	factory       output.PublisherFactory
+	kinesisClient *kinesis.Kinesis
+	manifest      *output.Manifest
+	g             *sync.WaitGroup
+
+	// initializing is the number of publishers being initialized, started is 1 once initial orgs are launched
+	initializing int32
+	started      int32
+
+	lock     sync.Mutex
+	interval time.Duration
+	members  map[string]*fleetMember
+
+	// publishersLock is held while a publisher is initialized, so pipelines can be managed meanwhile
+	publishersLock sync.Mutex
+	publishers     map[string]output.EventsPublisher
+	cleanups       []pipeline.CleanupFunc
+}
+
+type fleetMember struct {
+	pump   *pipeline.Pipeline
+	cancel context.CancelFunc
+}
+
+func newFleet(ctx context.Context, cfg config, factory output.PublisherFactory, kinesisClient *kinesis.Kinesis,
+	manifest *output.Manifest, g *sync.WaitGroup) *fleet {
+	return &fleet{
+		cfg:           cfg,
+		ctx:           ctx,
+		factory:       factory,
+		kinesisClient: kinesisClient,
+		manifest:      manifest,
+		g:             g,
+		interval:      time.Duration(cfg.interval) * time.Second,
+		members:       make(map[string]*fleetMember),
+		publishers:    make(map[string]output.EventsPublisher),
+	}
+}
+
+func memberKey(caseId events_generator.Case, orgId string) string {
+	return string(caseId) + "/" + orgId
+}
+
+// newOrg generates an org with stream settings of the run. Random size is picked unless it's set
+func (f *fleet) newOrg(caseId events_generator.Case, orgId string, size events_generator.OrgSize) *events_generator.Org {
+	if size == "" {
+		if f.cfg.orgSizeSet {
+			size = f.cfg.orgSize
+		} else {
+			size = events_generator.GuessOrgSize()
+		}
+	}
+
+	org := events_generator.GenerateOrg(orgId, size, caseId, f.cfg.debugEvents, f.cfg.prefix)
+	f.setupOrg(org)
+
+	return org
+}
+
+// setupOrg applies stream settings of the run to the org
+func (f *fleet) setupOrg(org *events_generator.Org) {
+	org.Sharing = f.cfg.streamSharing
+	org.Naming = f.cfg.streamNaming
+	org.Tagging = f.cfg.streamTagging
+	org.HistorySize = f.cfg.deviceHistory
+	org.Sequencing = f.cfg.eventFormat != output.RawFormat
+	org.SetBehavior(f.cfg.behavior)
+}
+
+// start launches pipelines of the orgs creating publishers for streams which don't have them yet
+func (f *fleet) start(orgs []*events_generator.Org) error {
+	for _, org := range orgs {
+		if err := f.startOrg(org); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *fleet) startOrg(org *events_generator.Org) error {
+	key := memberKey(org.CaseId, org.OrgId)
+	f.lock.Lock()
+	_, exists := f.members[key]
+	f.lock.Unlock()
+	if exists {
+		return errors.Wrapf(errDuplicateOrg, "org %s of case %s", org.OrgId, org.CaseId)
+	}
+
+	log.Infof("launching events generator for %s of org %s", org.StreamName(), org.OrgId)
+	publisher, err := f.publisher(org)
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.members[key]; ok { // the same org was added while the publisher was initialized
+		return errors.Wrapf(errDuplicateOrg, "org %s of case %s", org.OrgId, org.CaseId)
+	}
+
+	log.Infof("creating generator for %s", org.OrgId)
+	pump := pipeline.NewPipeline(publisher, org, f.interval)
+	ctx, cancel := context.WithCancel(f.ctx)
+	f.members[key] = &fleetMember{pump: pump, cancel: cancel}
+
+	f.g.Add(1)
+	go func(ctx context.Context, pump *pipeline.Pipeline, g *sync.WaitGroup) {
+		defer g.Done()
+		pump.Pump(ctx)
+	}(ctx, pump, f.g)
+
+	return nil
+}
+
+// publisher returns the publisher of the org stream. It's created and initialized if the stream doesn't have it yet
+func (f *fleet) publisher(org *events_generator.Org) (output.EventsPublisher, error) {
+	f.publishersLock.Lock()
+	defer f.publishersLock.Unlock()
+
+	if publisher, ok := f.publishers[org.StreamName()]; ok {
+		return publisher, nil
+	}
+
+	log.Infof("creating publisher for %s", org.StreamName())
+	publisher := f.factory(org)
+	resource := newResource(f.cfg, f.kinesisClient, org.StreamName())
+	atomic.AddInt32(&f.initializing, 1)
+	err := initRecorded(publisher, f.manifest, resource)
+	atomic.AddInt32(&f.initializing, -1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't provision publisher for %s", org.StreamName())
+	}
+	f.publishers[org.StreamName()] = publisher
+	f.cleanups = append(f.cleanups, recordedCleanup(publisher, f.manifest, resource))
+
+	return publisher, nil
+}
+
+func (f *fleet) hasPublisher(stream string) bool {
+	f.publishersLock.Lock()
+	defer f.publishersLock.Unlock()
+
+	_, ok := f.publishers[stream]
+	return ok
+}
+
+func (f *fleet) setStarted() {
+	atomic.StoreInt32(&f.started, 1)
+}
+
+// ready reports if initial orgs are launched and no publisher is being initialized
+func (f *fleet) ready() (bool, int) {
+	initializing := int(atomic.LoadInt32(&f.initializing))
+	return atomic.LoadInt32(&f.started) == 1 && initializing == 0, initializing
+}
+
+// add generates a new org for the case and launches its pipeline. The next free org id is used if it's empty
+func (f *fleet) add(caseId events_generator.Case, orgId string, size events_generator.OrgSize) (pipeline.Status, error) {
+	f.lock.Lock()
+	if orgId == "" {
+		orgId = f.nextOrgId(caseId)
+	}
+	_, exists := f.members[memberKey(caseId, orgId)]
+	orgs := make([]*events_generator.Org, 0, len(f.members)+1)
+	for _, member := range f.members {
+		orgs = append(orgs, member.pump.Org())
+	}
+	f.lock.Unlock()
+
+	if exists {
+		return pipeline.Status{}, errors.Wrapf(errDuplicateOrg, "org %s of case %s", orgId, caseId)
+	}
+
+	org := f.newOrg(caseId, orgId, size)
+	if err := validateStreams(append(orgs, org), f.cfg.output); err != nil {
+		return pipeline.Status{}, errors.Wrap(errInvalidOrg, err.Error())
+	}
+	if !f.hasPublisher(org.StreamName()) { // a new shared stream is sized for this org only
+		sizeSharedStreams([]*events_generator.Org{org}, f.cfg.sharedStreamShards)
+	}
+
+	if err := f.startOrg(org); err != nil {
+		return pipeline.Status{}, err
+	}
+
+	pump, err := f.pipeline(caseId, orgId)
+	if err != nil {
+		return pipeline.Status{}, err
+	}
+
+	return pump.Status(), nil
+}
+
+// nextOrgId returns the smallest numeric org id above ids of orgs of the case
+func (f *fleet) nextOrgId(caseId events_generator.Case) string {
+	next := f.cfg.startOrgId
+	for _, member := range f.members {
+		org := member.pump.Org()
+		if org.CaseId != caseId {
+			continue
+		}
+		if id, err := strconv.Atoi(org.OrgId); err == nil && id >= next {
+			next = id + 1
+		}
+	}
+
+	return strconv.Itoa(next)
+}
+
+// remove stops the pipeline of the org. Its stream stays until the generator exits since other orgs can share it
+func (f *fleet) remove(caseId events_generator.Case, orgId string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key := memberKey(caseId, orgId)
+	member, ok := f.members[key]
+	if !ok {
+		return errors.Wrapf(errUnknownOrg, "org %s of case %s", orgId, caseId)
+	}
+
+	member.cancel()
+	delete(f.members, key)
+	log.Infof("removed org %s of case %s", orgId, caseId)
+
+	return nil
+}
+
+func (f *fleet) pipeline(caseId events_generator.Case, orgId string) (*pipeline.Pipeline, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	member, ok := f.members[memberKey(caseId, orgId)]
+	if !ok {
+		return nil, errors.Wrapf(errUnknownOrg, "org %s of case %s", orgId, caseId)
+	}
+
+	return member.pump, nil
+}
+
+func (f *fleet) pipelines() []*pipeline.Pipeline {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	keys := make([]string, 0, len(f.members))
+	for key := range f.members {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pumps := make([]*pipeline.Pipeline, 0, len(keys))
+	for _, key := range keys {
+		pumps = append(pumps, f.members[key].pump)
+	}
+
+	return pumps
+}
+
+func (f *fleet) list() []pipeline.Status {
+	pumps := f.pipelines()
+	statuses := make([]pipeline.Status, 0, len(pumps))
+	for _, pump := range pumps {
+		statuses = append(statuses, pump.Status())
+	}
+
+	return statuses
+}
+
+// setInterval changes the interval of every pipeline and of pipelines added later
+func (f *fleet) setInterval(interval time.Duration) {
+	f.lock.Lock()
+	f.interval = interval
+	f.lock.Unlock()
+
+	for _, pump := range f.pipelines() {
+		pump.SetInterval(interval)
+	}
+}
+
+// shutdown delivers events which are still in flight and removes resources of the publishers if it's requested.
+// Pipelines have to be stopped before
+func (f *fleet) shutdown(cleanup bool) {
+	f.publishersLock.Lock()
+	defer f.publishersLock.Unlock()
+
+	shutdownPublishers(f.publishers, f.cleanups, cleanup)
+}
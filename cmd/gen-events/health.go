@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/melan/gen-events/events_generator"
+)
+
+type orgRef struct {
+	OrgId string                `json:"org_id"`
+	Case  events_generator.Case `json:"case"`
+}
+
+type healthResponse struct {
+	Status  string   `json:"status"`
+	Stalled []orgRef `json:"stalled,omitempty"`
+}
+
+type readinessResponse struct {
+	Status       string `json:"status"`
+	Initializing int    `json:"initializing_publishers"`
+}
+
+// registerHealthApi adds /healthz which fails when a pipeline hasn't completed a cycle for stalledCycles intervals
+// and /readyz which fails till all publishers are initialized
+func registerHealthApi(mux *http.ServeMux, f *fleet, stalledCycles int) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		stalled := make([]orgRef, 0)
+		for _, pump := range f.pipelines() {
+			if pump.Stalled(stalledCycles) {
+				org := pump.Org()
+				stalled = append(stalled, orgRef{OrgId: org.OrgId, Case: org.CaseId})
+			}
+		}
+
+		if len(stalled) > 0 {
+			writeJson(w, http.StatusServiceUnavailable, healthResponse{Status: "stalled", Stalled: stalled})
+			return
+		}
+		writeJson(w, http.StatusOK, healthResponse{Status: "ok"})
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready, initializing := f.ready()
+		if !ready {
+			writeJson(w, http.StatusServiceUnavailable, readinessResponse{Status: "initializing", Initializing: initializing})
+			return
+		}
+		writeJson(w, http.StatusOK, readinessResponse{Status: "ready"})
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/melan/gen-events/events_generator"
+)
+
+const defaultDevicesLimit = 100
+
+type devicesResponse struct {
+	Total   int                            `json:"total"`
+	Offset  int                            `json:"offset"`
+	Devices []events_generator.DeviceState `json:"devices"`
+}
+
+type deviceResponse struct {
+	events_generator.DeviceState
+	History []events_generator.DeviceHistoryEntry `json:"history"`
+}
+
+// registerIntrospectionApi adds read-only endpoints to look at devices of orgs:
+// GET /devices/{case}/{org}?state=&offset=&limit= and GET /devices/{case}/{org}/{device}
+func registerIntrospectionApi(mux *http.ServeMux, f *fleet) {
+	mux.HandleFunc("/devices/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/devices/"), "/"), "/")
+		if len(parts) < 2 || len(parts) > 3 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("path has to be /devices/{case}/{org} or /devices/{case}/{org}/{device}"))
+			return
+		}
+
+		pump, err := f.pipeline(events_generator.Case(parts[0]), parts[1])
+		if err != nil {
+			writeFleetError(w, err)
+			return
+		}
+		org := pump.Org()
+
+		if len(parts) == 3 {
+			state, history, ok, err := org.DeviceState(parts[2])
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if !ok {
+				writeError(w, http.StatusNotFound, fmt.Errorf("org %s of case %s has no device %s", org.OrgId, org.CaseId, parts[2]))
+				return
+			}
+			writeJson(w, http.StatusOK, deviceResponse{DeviceState: state, History: history})
+			return
+		}
+
+		query := r.URL.Query()
+		offset, err := queryInt(query.Get("offset"), 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		limit, err := queryInt(query.Get("limit"), defaultDevicesLimit)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		devices, total, err := org.DeviceStates(query.Get("state"), offset, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJson(w, http.StatusOK, devicesResponse{Total: total, Offset: offset, Devices: devices})
+	})
+}
+
+func queryInt(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q has to be a non-negative number", value)
+	}
+
+	return n, nil
+}
@@ -7,14 +7,15 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/melan/gen-events/events_generator"
+	"github.com/melan/gen-events/misc"
 	"github.com/melan/gen-events/output"
 	"github.com/melan/gen-events/pipeline"
 	"github.com/pkg/errors"
@@ -31,7 +32,15 @@ const (
 	FileOutput       = "file"
 )
 
+const (
+	RunCommand               = "run"
+	ReplayDeadLettersCommand = "replay-dead-letters"
+	CleanupCommand           = "cleanup"
+	VerifyCommand            = "verify"
+)
+
 type config struct {
+	command       string
 	configFile    string
 	caseIds       []events_generator.Case
 	orgSize       events_generator.OrgSize
@@ -44,10 +53,54 @@ type config struct {
 	debug         bool
 	output        Output
 	outDir        string
-	tags          map[string]*string
+	tags          map[string]string
 	dryRun        bool
 	interval      int
 	prefix        string
+
+	shardStatsInterval int
+	shardSkewThreshold float64
+
+	partitionKeys output.PartitionKeyConfig
+
+	deadLettersDir string
+	replayFiles    []string
+	a8mMaxRetries  int
+
+	streamSharing      events_generator.StreamSharing
+	sharedStreamShards int64
+	streamNaming       *events_generator.StreamNaming
+
+	autoTags      bool
+	runId         string
+	owner         string
+	expireAfter   time.Duration
+	streamTagging *events_generator.StreamTagging
+
+	cleanupPrefix string
+	cleanupTags   map[string]string
+	manifestPath  string
+
+	controlApi    bool
+	deviceHistory int
+	stalledCycles int
+
+	checkpointPath     string
+	checkpointInterval time.Duration
+	resume             bool
+	// startedAt is the start of the run or of the resumed one, schedules of the run are relative to it
+	startedAt time.Time
+
+	eventFormat         output.EventFormat
+	behavior            *events_generator.Behavior
+	schemaEvolutionFile string
+
+	faultRate  float64
+	faultTypes []output.FaultType
+
+	groundTruthDir string
+	verifyStreams  []string
+	verifyReport   string
 }
 
 func main() {
@@ -55,6 +108,7 @@ func main() {
 
 	cfg := parseArgs()
 	log.Infof("Initializing orgs with the following configuration: %#v", cfg)
+	log.Infof("run id is %s", cfg.runId)
 
 	if cfg.debug {
 		log.SetLevel(log.DebugLevel)
@@ -62,93 +116,126 @@ func main() {
 		log.SetLevel(log.InfoLevel)
 	}
 
+	var deadLetters *output.DeadLetterSink
+	if cfg.deadLettersDir != "" {
+		var err error
+		deadLetters, err = output.NewDeadLetterSink(cfg.deadLettersDir)
+		if err != nil {
+			log.WithError(err).Fatal("can't create dead letters sink")
+		}
+		defer deadLetters.Close()
+	}
+
+	switch cfg.command {
+	case ReplayDeadLettersCommand:
+		replayDeadLetters(cfg, deadLetters)
+		return
+	case CleanupCommand:
+		cleanupStreams(cfg)
+		return
+	case VerifyCommand:
+		verifyStreams(cfg)
+		return
+	}
+
+	var kinesisClient *kinesis.Kinesis
 	var publisherFactory output.PublisherFactory
 	switch cfg.output {
 	case KinesisOutput:
-		sess, err := session.NewSession()
+		kinesisClient = newKinesisClient()
+		publisherFactory = output.CreateKinesisPublisherFactory(kinesisClient, deadLetters)
+	case A8mKinesisOutput:
+		kinesisClient = newKinesisClient()
+		publisherFactory = output.CreateA8mKinesisPublisherFactory(kinesisClient, deadLetters, cfg.a8mMaxRetries)
+	default:
+		publisherFactory = output.CreateFilePublisherFactory(cfg.outDir, deadLetters)
+	}
+	if cfg.groundTruthDir != "" && !cfg.dryRun {
+		groundTruth, err := output.NewGroundTruthSink(cfg.groundTruthDir)
 		if err != nil {
-			log.WithError(err).Panic("can't create new AWS session")
+			log.WithError(err).Fatal("can't create ground truth sink")
 		}
-		kinesisClient := kinesis.New(sess)
-		publisherFactory = output.CreateKinesisPublisherFactory(kinesisClient, cfg.tags)
-	case A8mKinesisOutput:
-		sess, err := session.NewSession()
+		defer groundTruth.Close()
+		publisherFactory = output.WithGroundTruth(publisherFactory, groundTruth)
+	}
+	publisherFactory = output.WithFaults(publisherFactory, cfg.faultRate, cfg.faultTypes)
+	publisherFactory = output.WithEventFormat(publisherFactory, cfg.eventFormat)
+	publisherFactory = output.WithPartitionKeys(publisherFactory, cfg.partitionKeys)
+
+	var manifest *output.Manifest
+	if cfg.manifestPath != "" && !cfg.dryRun {
+		var err error
+		manifest, err = output.OpenManifest(cfg.manifestPath, cfg.runId)
 		if err != nil {
-			log.WithError(err).Panic("can't create new AWS session")
+			log.WithError(err).Fatal("can't open manifest")
 		}
-		kinesisClient := kinesis.New(sess)
-		publisherFactory = output.CreateA8mKinesisPublisherFactory(kinesisClient, cfg.tags)
-	default:
-		publisherFactory = output.CreateFilePublisherFactory(cfg.outDir)
+		defer manifest.Close()
 	}
 
-	// generate orgs
-	orgs := make([]*events_generator.Org, 0, cfg.orgsCount*len(cfg.caseIds))
-
-	// Define orgSize generator
-	var orgSizeGenerator func() events_generator.OrgSize
-	if cfg.orgSizeSet {
-		orgSizeGenerator = func() events_generator.OrgSize { return cfg.orgSize }
-	} else {
-		orgSizeGenerator = events_generator.GuessOrgSize
+	var resumed checkpoint
+	var found bool
+	if cfg.resume {
+		var err error
+		resumed, found, err = readCheckpoint(cfg.checkpointPath)
+		if err != nil {
+			log.WithError(err).Fatal("can't resume")
+		}
+		if found && !resumed.StartedAt.IsZero() { // schedules go on from where the resumed run was
+			cfg.startedAt = resumed.StartedAt
+		}
 	}
 
-	for _, caseId := range cfg.caseIds {
-		for j := cfg.startOrgId; j < cfg.orgsCount+cfg.startOrgId; j++ {
-			org := events_generator.GenerateOrg(fmt.Sprintf("%d", j), orgSizeGenerator(), caseId, cfg.debugEvents,
-				cfg.prefix)
-			orgs = append(orgs, org)
+	if cfg.schemaEvolutionFile != "" {
+		var err error
+		cfg.behavior.SchemaEvolution, err = events_generator.LoadSchemaEvolution(cfg.schemaEvolutionFile, cfg.startedAt)
+		if err != nil {
+			log.WithError(err).Fatal("can't use schema evolution")
 		}
 	}
 
 	mainContext, mainCancel := context.WithCancel(context.Background())
-
-	log.Infof("creating events generators for %d orgs", len(orgs))
 	g := &sync.WaitGroup{}
-	cleanups := make([]pipeline.CleanupFunc, 0, len(orgs))
-	abort := false
+	orgsFleet := newFleet(mainContext, cfg, publisherFactory, kinesisClient, manifest, g)
 
-	for _, org := range orgs {
-		if !cfg.dryRun {
-			log.Infof("launching events generator for %s of org %s", org.StreamName(), org.OrgId)
-			log.Infof("creating publisher for %s", org.OrgId)
-			publisher := publisherFactory(org)
-			if err := publisher.Init(); err != nil {
-				log.WithError(err).Error("can't provision publisher because of an error")
-				abort = true
-				mainCancel()
-				break
-			} else {
-				cleanups = append(cleanups, publisher.Cleanup)
-			}
+	var orgs []*events_generator.Org
+	if found {
+		var err error
+		orgs, err = orgsFleet.restore(resumed)
+		if err != nil {
+			log.WithError(err).Fatal("can't resume")
+		}
+		log.Infof("resuming %d orgs saved by run %s at %s", len(orgs), resumed.RunId, resumed.SavedAt)
+	} else if cfg.resume {
+		log.Warnf("there is no checkpoint %s to resume from, generating new orgs", cfg.checkpointPath)
+	}
 
-			log.Infof("creating generator for %s", org.OrgId)
-			pump := pipeline.NewPipeline(publisher, org, time.Duration(cfg.interval)*time.Second)
-			pipelineContext, _ := context.WithCancel(mainContext)
-			go func(ctx context.Context, pump *pipeline.Pipeline, g *sync.WaitGroup) {
-				g.Add(1)
-				if cfg.cleanupOnExit {
-					log.Infof("adding cleanup for %s", pump.OrgId)
-					defer pump.Cleanup(g)
-				} else {
-					defer g.Done()
-				}
-
-				pump.Pump(ctx)
-			}(pipelineContext, pump, g)
-		} else {
-			log.Infof("skipping launch of the events generator for %s because of dry run", org.StreamName())
+	// generate orgs
+	if orgs == nil {
+		orgs = make([]*events_generator.Org, 0, cfg.orgsCount*len(cfg.caseIds))
+		for _, caseId := range cfg.caseIds {
+			for j := cfg.startOrgId; j < cfg.orgsCount+cfg.startOrgId; j++ {
+				orgs = append(orgs, orgsFleet.newOrg(caseId, fmt.Sprintf("%d", j), ""))
+			}
 		}
 	}
+	if err := validateStreams(orgs, cfg.output); err != nil {
+		log.WithError(err).Fatal("stream names or tags are invalid")
+	}
+	sizeSharedStreams(orgs, cfg.sharedStreamShards)
 
-	if abort {
-		log.Infof("initialization was aborted. Exiting")
-		g.Wait()
-		os.Exit(1)
+	if (cfg.output == KinesisOutput || cfg.output == A8mKinesisOutput) && cfg.shardStatsInterval > 0 {
+		go output.ReportShardStats(mainContext, time.Duration(cfg.shardStatsInterval)*time.Second, cfg.shardSkewThreshold)
 	}
 
 	log.Infof("enabling metrics endpoint")
 	http.Handle("/metrics", promhttp.Handler())
+	if cfg.controlApi {
+		log.Infof("enabling control api")
+		registerControlApi(http.DefaultServeMux, orgsFleet)
+	}
+	registerIntrospectionApi(http.DefaultServeMux, orgsFleet)
+	registerTailApi(http.DefaultServeMux, orgsFleet)
+	registerHealthApi(http.DefaultServeMux, orgsFleet, cfg.stalledCycles)
 	g.Add(1)
 
 	sigs := make(chan os.Signal, 1)
@@ -173,24 +260,279 @@ func main() {
 		server.Shutdown(context.Background())
 	}(httpContext, server, g)
 
+	// serve health checks while publishers are being initialized
+	go func(server *http.Server) {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("http server failed")
+		}
+	}(server)
+
+	log.Infof("creating events generators for %d orgs", len(orgs))
+	if cfg.dryRun {
+		for _, org := range orgs {
+			log.Infof("skipping launch of the events generator for %s because of dry run", org.StreamName())
+		}
+	} else if err := orgsFleet.start(orgs); err != nil {
+		log.WithError(err).Error("can't provision publisher because of an error")
+		log.Infof("initialization was aborted. Exiting")
+		mainCancel()
+		g.Wait()
+		orgsFleet.shutdown(cfg.cleanupOnExit)
+		os.Exit(1)
+	}
+
+	orgsFleet.resume(resumed)
+	orgsFleet.setStarted()
+
+	checkpoints := cfg.checkpointPath != "" && !cfg.dryRun
+	if checkpoints && cfg.checkpointInterval > 0 {
+		g.Add(1)
+		go orgsFleet.saveCheckpointPeriodically(mainContext, cfg.checkpointPath, cfg.checkpointInterval, g)
+	}
+
 	log.Info("prime time")
-	server.ListenAndServe()
 	g.Wait()
+	if checkpoints {
+		if err := orgsFleet.saveCheckpoint(cfg.checkpointPath); err != nil {
+			log.WithError(err).Error("can't save checkpoint")
+		}
+	}
+	orgsFleet.shutdown(cfg.cleanupOnExit)
 	log.Info("bye bye")
 }
 
+// shutdownPublishers delivers events which are still in flight and removes resources of the publishers if it's requested
+func shutdownPublishers(publishers map[string]output.EventsPublisher, cleanups []pipeline.CleanupFunc, cleanup bool) {
+	for stream, publisher := range publishers {
+		log.Infof("flushing publisher for %s", stream)
+		publisher.Flush()
+	}
+
+	if !cleanup {
+		return
+	}
+
+	g := &sync.WaitGroup{}
+	for _, cleanupFunc := range cleanups {
+		g.Add(1)
+		go cleanupFunc(g)
+	}
+	g.Wait()
+}
+
+// validateStreams checks that names and tags of all streams follow rules of the output
+// and orgs don't share streams unless it's requested
+func validateStreams(orgs []*events_generator.Org, destination Output) error {
+	kinesisOutput := destination == KinesisOutput || destination == A8mKinesisOutput
+	validate := output.ValidateFileName
+	if kinesisOutput {
+		validate = output.ValidateKinesisStreamName
+	}
+
+	owners := make(map[string]*events_generator.Org, len(orgs))
+	for _, org := range orgs {
+		name, err := org.RenderStreamName()
+		if err != nil {
+			return err
+		}
+
+		if err := validate(name); err != nil {
+			return err
+		}
+
+		tags, err := org.StreamTags()
+		if err != nil {
+			return err
+		}
+		if kinesisOutput {
+			if err := output.ValidateKinesisTags(tags); err != nil {
+				return fmt.Errorf("tags of stream %s are invalid: %s", name, err)
+			}
+		}
+
+		if owner, ok := owners[name]; ok && org.Sharing == events_generator.NoSharing {
+			return fmt.Errorf("org %s of case %s and org %s of case %s get the same stream %s",
+				owner.OrgId, owner.CaseId, org.OrgId, org.CaseId, name)
+		}
+		owners[name] = org
+	}
+
+	return nil
+}
+
+// sizeSharedStreams sets number of shards of every shared stream to the sum of shards its orgs would have on their own,
+// unless it's set explicitly
+func sizeSharedStreams(orgs []*events_generator.Org, shards int64) {
+	streamShards := make(map[string]int64)
+	for _, org := range orgs {
+		if org.Sharing == events_generator.NoSharing {
+			continue
+		}
+		streamShards[org.StreamName()] += org.NumberOfStreamShards()
+	}
+
+	for _, org := range orgs {
+		if org.Sharing == events_generator.NoSharing {
+			continue
+		}
+
+		if shards > 0 {
+			org.StreamShards = shards
+		} else {
+			org.StreamShards = streamShards[org.StreamName()]
+		}
+	}
+}
+
+// newResource describes a stream or a file where the output publishes events
+func newResource(cfg config, client *kinesis.Kinesis, stream string) output.Resource {
+	if client == nil {
+		return output.Resource{
+			Type:     output.FileResource,
+			Name:     stream,
+			Output:   string(cfg.output),
+			Endpoint: cfg.outDir,
+		}
+	}
+
+	return output.Resource{
+		Type:     output.KinesisStreamResource,
+		Name:     stream,
+		Output:   string(cfg.output),
+		Endpoint: client.Endpoint,
+		Region:   client.SigningRegion,
+	}
+}
+
+// initRecorded initializes the publisher and records its stream in the manifest if the publisher creates it.
+// Streams which existed before aren't recorded, they belong to whoever created them
+func initRecorded(publisher output.EventsPublisher, manifest *output.Manifest, resource output.Resource) error {
+	intended := false
+	beforeCreate := func() error {
+		intended = true
+		return manifest.Creating(resource)
+	}
+	created, err := publisher.Init(beforeCreate)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		if err := manifest.Created(resource); err != nil {
+			log.WithError(err).Errorf("can't record %s in manifest", resource.Name)
+		}
+	} else if intended {
+		if err := manifest.Abandoned(resource); err != nil {
+			log.WithError(err).Errorf("can't record %s in manifest", resource.Name)
+		}
+	}
+
+	return nil
+}
+
+// recordedCleanup marks removed streams in the manifest. Files aren't removed by publishers, so they remain there
+func recordedCleanup(publisher output.EventsPublisher, manifest *output.Manifest, resource output.Resource) pipeline.CleanupFunc {
+	if resource.Type != output.KinesisStreamResource {
+		return publisher.Cleanup
+	}
+
+	return func(g *sync.WaitGroup) {
+		defer g.Done()
+
+		done := &sync.WaitGroup{}
+		done.Add(1)
+		publisher.Cleanup(done)
+		done.Wait()
+
+		if err := manifest.Removed(resource); err != nil {
+			log.WithError(err).Errorf("can't record removal of %s in manifest", resource.Name)
+		}
+	}
+}
+
+func newKinesisClient() *kinesis.Kinesis {
+	sess, err := session.NewSession()
+	if err != nil {
+		log.WithError(err).Panic("can't create new AWS session")
+	}
+
+	return kinesis.New(sess)
+}
+
+// newKinesisClientFor creates a client for a region and an endpoint other than configured in the environment
+func newKinesisClientFor(region string, endpoint string) *kinesis.Kinesis {
+	sess, err := session.NewSession()
+	if err != nil {
+		log.WithError(err).Panic("can't create new AWS session")
+	}
+
+	config := aws.NewConfig()
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+
+	return kinesis.New(sess, config)
+}
+
 func parseArgs() config {
 	cfg := config{}
 
 	a := kingpin.New(filepath.Base(os.Args[0]), "Generator of platform events")
 	a.HelpFlag.Short('h')
 
+	a.Command(RunCommand, "Generate events. This is the default command").Default()
+
+	replay := a.Command(ReplayDeadLettersCommand, "Publish events from dead letters files once again")
+	replay.Arg("files", "Dead letters files to replay. All files from --dead-letters-path by default").
+		ExistingFilesVar(&cfg.replayFiles)
+
+	cleanup := a.Command(CleanupCommand, "Remove streams left by previous runs. Streams are selected by --prefix and --match-tag "+
+		"or by --manifest, --dry-run only lists them. Without --prefix streams are selected only by tags")
+	var cleanupTagPairs []string
+	cleanup.Flag("match-tag", "Remove only streams with this tag pair delimited by '='. Can be used multiple times").
+		StringsVar(&cleanupTagPairs)
+
+	verify := a.Command(VerifyCommand, "Read streams or files back and compare them with --ground-truth recorded by a run")
+	verify.Arg("streams", "Streams or files to verify. All streams from --ground-truth by default").
+		StringsVar(&cfg.verifyStreams)
+	verify.Flag("report", "File to write the verification report to, including counts of mismatched devices").
+		Default("").StringVar(&cfg.verifyReport)
+
+	prefixSet := false
 	a.Flag("prefix", "This prefix will be added to all topics and files generated by this tool").
-		Default("default").StringVar(&cfg.prefix)
+		Default("default").Action(func(*kingpin.ParseContext) error {
+		prefixSet = true
+		return nil
+	}).StringVar(&cfg.prefix)
 
 	a.Flag("listen-address", "Address where prometheus /metrics endpoint will be available").
 		Default(":8080").StringVar(&cfg.listenAddr)
 
+	a.Flag("enable-control-api", "Serve endpoints to add, remove, pause and resume orgs and change intervals on --listen-address").
+		Default("false").BoolVar(&cfg.controlApi)
+
+	a.Flag("device-history", "Number of the latest cycles to keep in the history of every device for /devices endpoints. "+
+		"It takes memory for every device of every org").
+		Default("0").IntVar(&cfg.deviceHistory)
+
+	a.Flag("stalled-cycles", "/healthz fails if a pipeline hasn't completed a cycle for this many intervals").
+		Default("3").IntVar(&cfg.stalledCycles)
+
+	a.Flag("ground-truth", "Directory to record every published event into, so verify command can check what landed").
+		Default("").StringVar(&cfg.groundTruthDir)
+
+	a.Flag("checkpoint", "File to save state of orgs and devices to on exit").
+		Default("").StringVar(&cfg.checkpointPath)
+
+	a.Flag("checkpoint-interval", "Save state of orgs and devices to --checkpoint this often as well, e.g. 10m. Only on exit by default").
+		Default("0s").DurationVar(&cfg.checkpointInterval)
+
+	a.Flag("resume", "Restore orgs and devices from --checkpoint instead of generating new ones. New orgs are generated if it doesn't exist").
+		Default("false").BoolVar(&cfg.resume)
+
 	a.Flag("orgs-count", "Number of different Orgs to generate").
 		Default("1").IntVar(&cfg.orgsCount)
 
@@ -212,6 +554,13 @@ func parseArgs() config {
 	a.Flag("interval", "Interval in seconds between metrics generation cycles").
 		Default("60").IntVar(&cfg.interval)
 
+	a.Flag("shard-stats-interval", "Interval in seconds between summaries of records distribution between Kinesis shards "+
+		"of kinesis and a8m_kinesis outputs. 0 disables summaries").
+		Default("60").IntVar(&cfg.shardStatsInterval)
+
+	a.Flag("shard-skew-threshold", "Flag a stream as skewed when its busiest shard gets this many times more records than an average shard").
+		Default("2").Float64Var(&cfg.shardSkewThreshold)
+
 	var outputDestination string
 	a.Flag("output", "Destination for output").
 		Default(string(KinesisOutput)).
@@ -220,6 +569,118 @@ func parseArgs() config {
 			string(KinesisOutput),
 			string(FileOutput))
 
+	partitionKeyStrategies := make([]string, 0, len(output.AllPartitionKeyStrategies))
+	for _, strategy := range output.AllPartitionKeyStrategies {
+		partitionKeyStrategies = append(partitionKeyStrategies, string(strategy))
+	}
+	var partitionKey string
+	a.Flag("partition-key", "Strategy to assign partition keys to events").
+		Default(string(output.DeviceIdKey)).
+		EnumVar(&partitionKey, partitionKeyStrategies...)
+
+	a.Flag("partition-key-count", "Number of hot keys for 'zipf' strategy or number of hash key ranges for 'hash_range' strategy").
+		Default("10").IntVar(&cfg.partitionKeys.Count)
+
+	a.Flag("partition-key-zipf-s", "Skew of 'zipf' partition keys, has to be greater than 1").
+		Default("1.5").Float64Var(&cfg.partitionKeys.ZipfS)
+
+	a.Flag("partition-key-constant", "Partition key for 'constant' strategy").
+		Default("constant").StringVar(&cfg.partitionKeys.Constant)
+
+	eventFormats := make([]string, 0, len(output.AllEventFormats))
+	for _, format := range output.AllEventFormats {
+		eventFormats = append(eventFormats, string(format))
+	}
+	var eventFormat string
+	a.Flag("event-format", "Publish events as they are generated, wrap them into an envelope with event id, "+
+		"sequence number of the event of the device, org id, case, emission time and schema version, "+
+		"or into CloudEvents structured mode events").
+		Default(string(output.RawFormat)).
+		EnumVar(&eventFormat, eventFormats...)
+
+	var heartbeatStatuses bool
+	a.Flag("heartbeat-statuses", "Heartbeat devices send UP, DEGRADED, DOWN and RECOVERING statuses changing by a Markov model "+
+		"besides going silent").
+		Default("false").BoolVar(&heartbeatStatuses)
+
+	var statusModelFile string
+	a.Flag("heartbeat-status-model", "JSON file with transition probabilities of heartbeat statuses per cycle, "+
+		"e.g. {\"transitions\": {\"UP\": {\"DOWN\": 0.05}, \"DOWN\": {\"UP\": 0.3}}}. Implies --heartbeat-statuses").
+		Default("").StringVar(&statusModelFile)
+
+	var storeAndForward bool
+	a.Flag("store-and-forward", "Heartbeat and broken temperature devices buffer events while they are down "+
+		"and send them with their original times when they are back").
+		Default("false").BoolVar(&storeAndForward)
+
+	var storeAndForwardMaxEvents int
+	a.Flag("store-and-forward-max-events", "Number of events a device can buffer, the oldest ones are dropped "+
+		"when it's full. 0 means no limit").
+		Default("0").IntVar(&storeAndForwardMaxEvents)
+
+	var latenessFile string
+	a.Flag("lateness", "JSON file with distributions of late and future-dated events per case, "+
+		"e.g. {\"heartbeat_message\": {\"distribution\": \"pareto\", \"ratio\": 0.05, \"min_sec\": 60, \"shape\": 1.5}}. "+
+		"It replaces late events built into the cases").
+		Default("").StringVar(&latenessFile)
+
+	var duplicates events_generator.Duplicates
+	a.Flag("duplicates-exact", "Share of events emitted once again in the same cycle").
+		Default("0").Float64Var(&duplicates.ExactRatio)
+
+	a.Flag("duplicates-delayed", "Share of events emitted once again in one of next --duplicates-max-delay-cycles cycles").
+		Default("0").Float64Var(&duplicates.DelayedRatio)
+
+	a.Flag("duplicates-max-delay-cycles", "Delayed duplicates come this many cycles after their events at most").
+		Default("5").IntVar(&duplicates.MaxDelayCycles)
+
+	a.Flag("duplicates-near", "Share of events emitted once again with the same device, time and sequence number "+
+		"but a different payload").
+		Default("0").Float64Var(&duplicates.NearRatio)
+
+	a.Flag("schema-evolution", "JSON file with versions of schemas of events per case. Every version adds, renames, "+
+		"removes fields or changes their types and is rolled out to devices gradually, "+
+		"e.g. {\"temperature_reading\": [{\"at_sec\": 300, \"rollout_sec\": 600, "+
+		"\"changes\": [{\"op\": \"change_type\", \"field\": \"temp\", \"type\": \"float\"}]}]}").
+		Default("").StringVar(&cfg.schemaEvolutionFile)
+
+	var clocks events_generator.ClockModel
+	a.Flag("clock-offset", "Standard deviation of offsets of clocks of devices in seconds. "+
+		"Devices use the host clock unless any of --clock-* flags is set").
+		Default("0").Float64Var(&clocks.OffsetStdDevSec)
+
+	a.Flag("clock-drift-ppm", "Standard deviation of drift rates of clocks of devices in parts per million").
+		Default("0").Float64Var(&clocks.DriftStdDevPpm)
+
+	a.Flag("clock-ntp-rate", "Share of devices which correct their clocks in a cycle, removing the offset and the drift").
+		Default("0").Float64Var(&clocks.NtpRate)
+
+	a.Flag("clock-epoch-reset-rate", "Share of devices which clocks are reset to the unix epoch in a cycle. "+
+		"They count from the epoch until they correct their clocks").
+		Default("0").Float64Var(&clocks.EpochResetRate)
+
+	timestampFormats := make([]string, 0, len(events_generator.AllTimestampFormats))
+	for _, format := range events_generator.AllTimestampFormats {
+		timestampFormats = append(timestampFormats, string(format))
+	}
+	var timestampFormat string
+	a.Flag("timestamp-format", "Format of times of events: unix seconds, milliseconds, microseconds or nanoseconds, "+
+		"RFC 3339 strings with zone offsets of devices or local time strings of devices without zones").
+		Default(string(events_generator.UnixSeconds)).
+		EnumVar(&timestampFormat, timestampFormats...)
+
+	a.Flag("fault-rate", "Share of events published malformed by one of --fault-type").
+		Default("0").Float64Var(&cfg.faultRate)
+
+	faultTypeNames := make([]string, 0, len(output.AllFaultTypes))
+	for _, fault := range output.AllFaultTypes {
+		faultTypeNames = append(faultTypeNames, string(fault))
+	}
+	var faultTypes []string
+	a.Flag("fault-type", "Type of malformed events: truncated JSON, wrong types of fields, a missing field, invalid UTF-8, "+
+		"records over 1 MiB or empty records. Can be used multiple times, all types by default").
+		EnumsVar(&faultTypes, faultTypeNames...)
+
 	var outDir string
 	a.Flag("output-path", "Path to output file").
 		Default("").StringVar(&outDir)
@@ -241,15 +702,60 @@ func parseArgs() config {
 			string(events_generator.MediumOrg),
 			string(events_generator.LargeOrg))
 
+	a.Flag("manifest", "File to record every stream and file created by the tool. cleanup command removes what's left in it").
+		Default("").StringVar(&cfg.manifestPath)
+
+	a.Flag("dead-letters-path", "Directory to store events which could not be delivered. Undelivered events are dropped if it's unset").
+		Default("").StringVar(&cfg.deadLettersDir)
+
+	a.Flag("a8m-max-retries", "How many times a8m_kinesis output puts a failed record back before sending it to dead letters").
+		Default("3").IntVar(&cfg.a8mMaxRetries)
+
+	var streamSharing string
+	a.Flag("stream-sharing", "Publish events of all orgs of a case or of all cases into a single stream. "+
+		"Events get org_id and case fields when a stream is shared").
+		Default(string(events_generator.NoSharing)).
+		EnumVar(&streamSharing,
+			string(events_generator.NoSharing),
+			string(events_generator.CaseSharing),
+			string(events_generator.AllSharing))
+
+	a.Flag("shared-stream-shards", "Number of shards of a shared stream. By default it's the sum of shards of all orgs sharing it").
+		Default("0").Int64Var(&cfg.sharedStreamShards)
+
+	var streamNameTemplate string
+	a.Flag("stream-name-template", "Go template for names of streams and files. "+
+		"Available fields: .Prefix, .Case, .OrgId, .OrgSize, .Shared and .Tags, functions: lower, upper, replace, trunc").
+		Default("").StringVar(&streamNameTemplate)
+
 	var tagsPairs []string
-	a.Flag("tag", "Tag pair delimited by `=`. Can be used multiple times").StringsVar(&tagsPairs)
+	a.Flag("tag", "Tag pair delimited by `=`. Value can be a Go template with the same data as --stream-name-template. "+
+		"Can be used multiple times").StringsVar(&tagsPairs)
+
+	a.Flag("auto-tags", "Tag streams with case, org id, org size, version of the tool, run id, owner and expiration time").
+		Default("false").BoolVar(&cfg.autoTags)
+
+	a.Flag("run-id", "Id of this run for automatic tags. Generated from the start time by default").
+		Default("").StringVar(&cfg.runId)
 
-	_, err := a.Parse(os.Args[1:])
+	a.Flag("owner", "Owner of streams for automatic tags").
+		Default(os.Getenv("USER")).StringVar(&cfg.owner)
+
+	a.Flag("expire-after", "Streams are tagged as expired after this duration, e.g. 24h. Not tagged by default").
+		Default("0s").DurationVar(&cfg.expireAfter)
+
+	command, err := a.Parse(os.Args[1:])
 	if err != nil {
 		fmt.Fprintln(os.Stderr, errors.Wrapf(err, "Error parsing commandline arguments"))
 		a.Usage(os.Args[1:])
 		os.Exit(2)
 	}
+	cfg.command = command
+
+	// cleanup matches the prefix only if it's set explicitly, otherwise streams are selected by tags
+	if prefixSet {
+		cfg.cleanupPrefix = cfg.prefix
+	}
 
 	if len(caseIds) > 0 {
 		cases := make(map[events_generator.Case]bool, len(caseIds))
@@ -273,10 +779,73 @@ func parseArgs() config {
 		cfg.orgSizeSet = false
 	}
 
+	cfg.partitionKeys.Strategy = output.PartitionKeyStrategy(partitionKey)
+	if cfg.partitionKeys.Strategy == output.ZipfKey && cfg.partitionKeys.ZipfS <= 1 {
+		log.Fatalf("--partition-key-zipf-s has to be greater than 1, got %f", cfg.partitionKeys.ZipfS)
+	}
+
+	cfg.streamSharing = events_generator.StreamSharing(streamSharing)
+	cfg.eventFormat = output.EventFormat(eventFormat)
+
+	cfg.behavior = &events_generator.Behavior{}
+	if statusModelFile != "" {
+		cfg.behavior.Statuses, err = events_generator.LoadStatusModel(statusModelFile)
+		if err != nil {
+			log.WithError(err).Fatal("can't use heartbeat status model")
+		}
+	} else if heartbeatStatuses {
+		cfg.behavior.Statuses = events_generator.DefaultStatusModel()
+	}
+	if latenessFile != "" {
+		cfg.behavior.Lateness, err = events_generator.LoadLateness(latenessFile)
+		if err != nil {
+			log.WithError(err).Fatal("can't use lateness")
+		}
+	}
+	if duplicates.ExactRatio < 0 || duplicates.DelayedRatio < 0 || duplicates.NearRatio < 0 ||
+		duplicates.ExactRatio+duplicates.DelayedRatio+duplicates.NearRatio > 1 {
+		log.Fatal("--duplicates-exact, --duplicates-delayed and --duplicates-near have to be positive and add up to 1 at most")
+	}
+	if duplicates.MaxDelayCycles < 1 {
+		log.Fatalf("--duplicates-max-delay-cycles has to be positive, got %d", duplicates.MaxDelayCycles)
+	}
+	if duplicates.ExactRatio+duplicates.DelayedRatio+duplicates.NearRatio > 0 {
+		cfg.behavior.Duplicates = &duplicates
+	}
+	if clocks.OffsetStdDevSec < 0 || clocks.DriftStdDevPpm < 0 || clocks.NtpRate < 0 || clocks.EpochResetRate < 0 ||
+		clocks.NtpRate+clocks.EpochResetRate > 1 {
+		log.Fatal("--clock-* flags have to be positive, --clock-ntp-rate and --clock-epoch-reset-rate add up to 1 at most")
+	}
+	if clocks != (events_generator.ClockModel{}) {
+		cfg.behavior.Clocks = &clocks
+	}
+	cfg.behavior.TimestampFormat = events_generator.TimestampFormat(timestampFormat)
+	if storeAndForward {
+		cfg.behavior.StoreAndForward = &events_generator.StoreAndForward{MaxEvents: storeAndForwardMaxEvents}
+	}
+
+	if cfg.faultRate < 0 || cfg.faultRate > 1 {
+		log.Fatalf("--fault-rate has to be between 0 and 1, got %f", cfg.faultRate)
+	}
+	if len(faultTypes) == 0 {
+		cfg.faultTypes = output.AllFaultTypes
+	}
+	for _, fault := range faultTypes {
+		cfg.faultTypes = append(cfg.faultTypes, output.FaultType(fault))
+	}
+
+	if cfg.resume && cfg.checkpointPath == "" {
+		log.Fatal("--resume requires --checkpoint")
+	}
+
 	if outputDestination != "" {
 		cfg.output = Output(outputDestination)
 	}
 
+	if cfg.partitionKeys.Strategy == output.HashRangeKey && cfg.output != KinesisOutput {
+		log.Fatalf("--partition-key %s sets explicit hash keys which only %s output supports", output.HashRangeKey, KinesisOutput)
+	}
+
 	if cfg.output == FileOutput {
 		if outDir != "" {
 			absPath, err := filepath.Abs(outDir)
@@ -295,18 +864,37 @@ func parseArgs() config {
 		}
 	}
 
-	cfg.tags = make(map[string]*string)
-	if tagsPairs != nil && len(tagsPairs) > 0 {
-		for _, tagPair := range tagsPairs {
-			split := strings.Split(tagPair, "=")
-			if len(split) != 2 {
-				log.Infof("can't parse tag %s. Skipping", tagPair)
-				continue
-			}
+	cfg.tags = parseTagPairs(tagsPairs)
+	cfg.cleanupTags = parseTagPairs(cleanupTagPairs)
 
-			cfg.tags[split[0]] = &split[1]
+	if streamNameTemplate != "" {
+		cfg.streamNaming, err = events_generator.NewStreamNaming(streamNameTemplate, cfg.tags)
+		if err != nil {
+			log.WithError(err).Fatal("can't use stream name template")
 		}
 	}
 
+	cfg.startedAt = time.Now().UTC()
+	if cfg.runId == "" {
+		now := cfg.startedAt
+		cfg.runId = fmt.Sprintf("%s-%04x", now.Format("20060102T150405Z"), now.UnixNano()%0x10000)
+	}
+
+	runTags := map[string]string{
+		events_generator.VersionTag: misc.Version,
+		events_generator.RunIdTag:   cfg.runId,
+	}
+	if cfg.owner != "" {
+		runTags[events_generator.OwnerTag] = cfg.owner
+	}
+	if cfg.expireAfter > 0 {
+		runTags[events_generator.ExpiresAtTag] = time.Now().Add(cfg.expireAfter).UTC().Format(time.RFC3339)
+	}
+
+	cfg.streamTagging, err = events_generator.NewStreamTagging(cfg.tags, cfg.autoTags, runTags)
+	if err != nil {
+		log.WithError(err).Fatal("can't use tags")
+	}
+
 	return cfg
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/melan/gen-events/events_generator"
+	"github.com/melan/gen-events/output"
+	log "github.com/sirupsen/logrus"
+)
+
+const replayBatchSize = 500
+
+// replayingSuffix marks dead letters files which are being replayed. A file is left with it if the replay is interrupted
+const replayingSuffix = ".replaying"
+
+func replayDeadLetters(cfg config, deadLetters *output.DeadLetterSink) {
+	files := cfg.replayFiles
+	if len(files) == 0 {
+		if cfg.deadLettersDir == "" {
+			log.Fatal("nothing to replay: neither dead letters files nor --dead-letters-path are set")
+		}
+
+		// interrupted replays go first, so they are finished before the files they were moved from fill up again
+		for _, pattern := range []string{"*" + output.DeadLettersSuffix + replayingSuffix, "*" + output.DeadLettersSuffix} {
+			matches, err := filepath.Glob(filepath.Join(cfg.deadLettersDir, pattern))
+			if err != nil {
+				log.WithError(err).Fatalf("can't list dead letters in %s", cfg.deadLettersDir)
+			}
+			files = append(files, matches...)
+		}
+	}
+
+	var manifest *output.Manifest
+	if cfg.manifestPath != "" {
+		var err error
+		manifest, err = output.OpenManifest(cfg.manifestPath, cfg.runId)
+		if err != nil {
+			log.WithError(err).Fatal("can't open manifest")
+		}
+		defer manifest.Close()
+	}
+
+	var client *kinesis.Kinesis
+	if cfg.output == KinesisOutput || cfg.output == A8mKinesisOutput {
+		client = newKinesisClient()
+	}
+	newPublisher := newStreamPublisherFactory(cfg, client, deadLetters)
+	initPublisher := func(publisher output.EventsPublisher, stream string) error {
+		return initRecorded(publisher, manifest, newResource(cfg, client, stream))
+	}
+
+	failed := false
+	for _, fileName := range files {
+		if err := replayFile(fileName, newPublisher, initPublisher, deadLetters); err != nil {
+			log.WithError(err).Errorf("can't replay dead letters from %s", fileName)
+			failed = true
+		}
+	}
+
+	if failed {
+		manifest.Close()
+		os.Exit(1)
+	}
+}
+
+func replayFile(fileName string, newPublisher func(stream string) output.EventsPublisher,
+	initPublisher func(publisher output.EventsPublisher, stream string) error, deadLetters *output.DeadLetterSink) error {
+	// move the file aside, so events failing once again don't end up in the file which is being replayed.
+	// A file left by an interrupted replay is resumed where it is
+	replaying := fileName
+	if !strings.HasSuffix(fileName, replayingSuffix) {
+		replaying = fileName + replayingSuffix
+		if _, err := os.Stat(replaying); err == nil {
+			return fmt.Errorf("%s is left by an interrupted replay, replay it first", replaying)
+		}
+		if err := os.Rename(fileName, replaying); err != nil {
+			return err
+		}
+	}
+
+	letters, err := output.ReadDeadLetters(replaying)
+	if err != nil {
+		return err
+	}
+
+	streams := make([]string, 0)
+	events := make(map[string][]events_generator.Event)
+	pending := make(map[string][]output.DeadLetter)
+	for _, letter := range letters {
+		e := letter.Event()
+		if e == nil {
+			log.Debugf("dead letter for %s with partition key %s has no data and can't be replayed", letter.Stream, letter.PartitionKey)
+			deadLetters.Send(letter)
+			continue
+		}
+
+		if _, ok := events[letter.Stream]; !ok {
+			streams = append(streams, letter.Stream)
+		}
+		events[letter.Stream] = append(events[letter.Stream], e)
+		pending[letter.Stream] = append(pending[letter.Stream], letter)
+	}
+
+	for i, stream := range streams {
+		publisher := newPublisher(stream)
+		if err := initPublisher(publisher, stream); err != nil {
+			return fmt.Errorf("can't initialize publisher for %s, replay can be resumed from %s: %s", stream, replaying, err)
+		}
+
+		streamEvents := events[stream]
+		for start := 0; start < len(streamEvents); start += replayBatchSize {
+			end := start + replayBatchSize
+			if end > len(streamEvents) {
+				end = len(streamEvents)
+			}
+			publisher.Publish(streamEvents[start:end])
+		}
+		publisher.Flush()
+
+		log.Infof("replayed %d events into %s", len(streamEvents), stream)
+
+		// letters of replayed streams are removed, so resuming the replay doesn't publish them again
+		left := make([]output.DeadLetter, 0)
+		for _, s := range streams[i+1:] {
+			left = append(left, pending[s]...)
+		}
+		if err := output.WriteDeadLetters(replaying, left); err != nil {
+			return fmt.Errorf("can't record progress of the replay in %s, %s is replayed already: %s", replaying, stream, err)
+		}
+	}
+
+	return os.Remove(replaying)
+}
+
+func newStreamPublisherFactory(cfg config, client *kinesis.Kinesis,
+	deadLetters *output.DeadLetterSink) func(stream string) output.EventsPublisher {
+	switch cfg.output {
+	case KinesisOutput:
+		tags := replayTags(cfg)
+		return func(stream string) output.EventsPublisher {
+			return output.NewKinesisEventsPublisher(client, stream, 1, tags, deadLetters)
+		}
+	case A8mKinesisOutput:
+		tags := replayTags(cfg)
+		return func(stream string) output.EventsPublisher {
+			return output.NewA8mKinesisPublisher(client, stream, 1, tags, deadLetters, cfg.a8mMaxRetries)
+		}
+	default:
+		return func(stream string) output.EventsPublisher {
+			return output.NewFileEventsPublisher(cfg.outDir, stream, deadLetters)
+		}
+	}
+}
+
+// replayTags are tags for streams which are created by replay. Orgs of dead letters are unknown,
+// so tags which depend on them are dropped
+func replayTags(cfg config) map[string]*string {
+	tags, err := cfg.streamTagging.Tags(events_generator.StreamNameData{Prefix: cfg.prefix})
+	if err != nil {
+		log.WithError(err).Fatal("can't render tags")
+	}
+
+	for k, v := range tags {
+		if v == "" {
+			delete(tags, k)
+		}
+	}
+
+	return output.ToKinesisTags(tags)
+}
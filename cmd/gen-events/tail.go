@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+
+	"github.com/melan/gen-events/events_generator"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultTailMax = 100
+
+type tailedEvent struct {
+	OrgId string                `json:"org_id"`
+	Case  events_generator.Case `json:"case"`
+	Event json.RawMessage       `json:"event"`
+}
+
+type tailedCycle struct {
+	org    *events_generator.Org
+	events []events_generator.Event
+}
+
+// registerTailApi adds GET /events/tail which streams events of every cycle as Server-Sent Events.
+// Orgs are selected by org and case parameters, all orgs by default. sample is the share of events to send
+// and max limits number of events per cycle of an org, 0 sends all of them
+func registerTailApi(mux *http.ServeMux, f *fleet) {
+	mux.HandleFunc("/events/tail", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming isn't supported"))
+			return
+		}
+
+		query := r.URL.Query()
+		sample := 1.0
+		if value := query.Get("sample"); value != "" {
+			var err error
+			sample, err = strconv.ParseFloat(value, 64)
+			if err != nil || sample <= 0 || sample > 1 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("sample has to be in (0, 1], got %q", value))
+				return
+			}
+		}
+		max, err := queryInt(query.Get("max"), defaultTailMax)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		orgId, caseId := query.Get("org"), events_generator.Case(query.Get("case"))
+		cycles := make(chan tailedCycle)
+		done := make(chan struct{})
+		defer close(done)
+
+		tapped := 0
+		for _, pump := range f.pipelines() {
+			org := pump.Org()
+			if (orgId != "" && org.OrgId != orgId) || (caseId != "" && org.CaseId != caseId) {
+				continue
+			}
+
+			tap, untap := pump.Tap()
+			defer untap()
+			tapped++
+
+			go func(org *events_generator.Org, tap <-chan []events_generator.Event) {
+				for {
+					select {
+					case events := <-tap:
+						select {
+						case cycles <- tailedCycle{org: org, events: events}:
+						case <-done:
+							return
+						}
+					case <-done:
+						return
+					}
+				}
+			}(org, tap)
+		}
+
+		if tapped == 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no orgs match org %q and case %q", orgId, caseId))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case cycle := <-cycles:
+				if err := writeTailedCycle(w, cycle, sample, max); err != nil {
+					log.WithError(err).Debug("tail client is gone")
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			case <-f.ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+func writeTailedCycle(w http.ResponseWriter, cycle tailedCycle, sample float64, max int) error {
+	sent := 0
+	for _, e := range cycle.events {
+		if max > 0 && sent >= max {
+			break
+		}
+		if sample < 1 && rand.Float64() >= sample {
+			continue
+		}
+
+		js, err := e.ToJson()
+		if err != nil {
+			continue
+		}
+
+		data, err := json.Marshal(tailedEvent{OrgId: cycle.org.OrgId, Case: cycle.org.CaseId, Event: js})
+		if err != nil {
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		sent++
+	}
+
+	return nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/melan/gen-events/events_generator"
+	"github.com/melan/gen-events/output"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// verifySince is how long before the first published event records are read from Kinesis
+const verifySince = 1 * time.Minute
+
+type deviceCounts struct {
+	Expected int `json:"expected"`
+	Received int `json:"received"`
+	// Gaps is the number of sequence numbers missing between received events of the device
+	Gaps int `json:"gaps,omitempty"`
+}
+
+type percentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+type verifyReport struct {
+	Stream       string `json:"stream"`
+	Expected     int    `json:"expected"`
+	Received     int    `json:"received"`
+	DeadLettered int    `json:"dead_lettered"`
+	Missing      int    `json:"missing"`
+	Duplicated   int    `json:"duplicated"`
+	Unexpected   int    `json:"unexpected"`
+	// SequenceGaps is the number of sequence numbers missing between received events of every device
+	SequenceGaps int `json:"sequence_gaps"`
+	// InjectedDuplicates are expected events which were published as duplicates on purpose
+	InjectedDuplicates int `json:"injected_duplicates"`
+	// InjectedFaults are numbers of expected events which were published malformed on purpose by the fault type
+	InjectedFaults map[output.FaultType]int `json:"injected_faults,omitempty"`
+	// Devices have only devices which didn't get exactly what was published or have gaps
+	Devices map[string]*deviceCounts `json:"devices"`
+	// LatencyMs is time between handing events to the publisher and their arrival into the stream
+	LatencyMs *percentiles `json:"latency_ms,omitempty"`
+	// EventTimeLagMs is time between the event time and its arrival into the stream
+	EventTimeLagMs *percentiles `json:"event_time_lag_ms,omitempty"`
+}
+
+// verifyStreams reads streams back and compares them with ground truth recorded when events were published
+func verifyStreams(cfg config) {
+	if cfg.groundTruthDir == "" {
+		log.Fatal("verify requires --ground-truth with files recorded by a run")
+	}
+
+	streams := cfg.verifyStreams
+	if len(streams) == 0 {
+		files, err := filepath.Glob(filepath.Join(cfg.groundTruthDir, "*"+output.GroundTruthSuffix))
+		if err != nil {
+			log.WithError(err).Fatalf("can't list ground truth in %s", cfg.groundTruthDir)
+		}
+		for _, file := range files {
+			streams = append(streams, strings.TrimSuffix(filepath.Base(file), output.GroundTruthSuffix))
+		}
+	}
+
+	reports := make([]verifyReport, 0, len(streams))
+	failed := false
+	for _, stream := range streams {
+		report, err := verifyStream(cfg, stream)
+		if err != nil {
+			log.WithError(err).Errorf("can't verify %s", stream)
+			failed = true
+			continue
+		}
+
+		logReport(report)
+		if report.Missing > 0 || report.Duplicated > 0 || report.SequenceGaps > 0 {
+			failed = true
+		}
+		reports = append(reports, report)
+	}
+
+	if cfg.verifyReport != "" {
+		js, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			log.WithError(err).Fatal("can't serialize verification report")
+		}
+		if err := ioutil.WriteFile(cfg.verifyReport, js, 0644); err != nil {
+			log.WithError(err).Fatalf("can't write verification report to %s", cfg.verifyReport)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func verifyStream(cfg config, stream string) (verifyReport, error) {
+	report := verifyReport{Stream: stream, Devices: make(map[string]*deviceCounts)}
+
+	entries, err := output.ReadGroundTruth(output.GroundTruthFile(cfg.groundTruthDir, stream))
+	if err != nil {
+		return report, errors.Wrap(err, "can't read ground truth")
+	}
+
+	expected := make(map[string]int, len(entries))
+	devices := make(map[string]string, len(entries))
+	sequences := make(map[string]uint64, len(entries))
+	publishedAt := make(map[string]int64, len(entries))
+	var firstPublished int64
+	kinesisOutput := cfg.output == KinesisOutput || cfg.output == A8mKinesisOutput
+	for _, entry := range entries {
+		if kinesisOutput && entry.Fault == output.OversizedFault { // Kinesis rejects them, so they're always dead-lettered
+			report.DeadLettered++
+		} else {
+			expected[entry.Hash]++
+		}
+		devices[entry.Hash] = entry.Device
+		if entry.Seq > 0 {
+			sequences[entry.Hash] = entry.Seq
+		}
+		if entry.Duplicate != "" {
+			report.InjectedDuplicates++
+		}
+		if entry.Fault != "" {
+			if report.InjectedFaults == nil {
+				report.InjectedFaults = make(map[output.FaultType]int)
+			}
+			report.InjectedFaults[entry.Fault]++
+		}
+		if published, ok := publishedAt[entry.Hash]; !ok || entry.PublishedAt < published {
+			publishedAt[entry.Hash] = entry.PublishedAt
+		}
+		if firstPublished == 0 || entry.PublishedAt < firstPublished {
+			firstPublished = entry.PublishedAt
+		}
+	}
+
+	// events which were dead-lettered aren't expected in the stream
+	if cfg.deadLettersDir != "" {
+		letters, err := output.ReadDeadLetters(output.DeadLettersFile(cfg.deadLettersDir, stream))
+		if err != nil && !os.IsNotExist(err) {
+			return report, errors.Wrap(err, "can't read dead letters")
+		}
+		for _, letter := range letters {
+			data := [][]byte{letter.Data}
+			if userRecords, aggregated := output.Deaggregate(letter.Data); aggregated {
+				data = data[:0]
+				for _, userRecord := range userRecords {
+					data = append(data, userRecord.Data)
+				}
+			}
+
+			for _, d := range data {
+				hash := output.RecordHash(d)
+				if expected[hash] > 0 {
+					expected[hash]--
+					report.DeadLettered++
+				}
+			}
+		}
+	}
+
+	received := make(map[string]int, len(expected))
+	latencies := make([]float64, 0)
+	lags := make([]float64, 0)
+	read := func(record output.StreamRecord) {
+		hash := output.RecordHash(record.Data)
+		received[hash]++
+		if _, ok := devices[hash]; !ok {
+			report.Unexpected++
+			return
+		}
+
+		if record.ArrivedAt.IsZero() {
+			return
+		}
+		arrivedAt := record.ArrivedAt.UnixNano() / int64(time.Millisecond)
+		if received[hash] == 1 {
+			latencies = append(latencies, float64(arrivedAt-publishedAt[hash]))
+		}
+		if eventTime, ok := parseEventTime(record.Data); ok {
+			lags = append(lags, float64(arrivedAt-eventTime.UnixNano()/int64(time.Millisecond)))
+		}
+	}
+
+	switch cfg.output {
+	case KinesisOutput, A8mKinesisOutput:
+		since := time.Unix(0, firstPublished*int64(time.Millisecond)).Add(-verifySince)
+		err = output.ReadKinesisStream(newKinesisClient(), stream, since, read)
+	default:
+		err = output.ReadFile(cfg.outDir, stream, read)
+	}
+	if err != nil {
+		return report, errors.Wrap(err, "can't read events back")
+	}
+
+	for hash, count := range expected {
+		report.Expected += count
+		device := devices[hash]
+		if _, ok := report.Devices[device]; !ok {
+			report.Devices[device] = &deviceCounts{}
+		}
+		report.Devices[device].Expected += count
+	}
+	receivedSequences := make(map[string][]uint64)
+	for hash, count := range received {
+		report.Received += count
+		device, ok := devices[hash]
+		if !ok {
+			continue
+		}
+		report.Devices[device].Received += count
+		if seq, ok := sequences[hash]; ok {
+			receivedSequences[device] = append(receivedSequences[device], seq)
+		}
+
+		if count > expected[hash] {
+			report.Duplicated += count - expected[hash]
+		}
+	}
+	for hash, count := range expected {
+		if count > received[hash] {
+			report.Missing += count - received[hash]
+		}
+	}
+
+	for device, seqs := range receivedSequences {
+		gaps := sequenceGaps(seqs)
+		report.Devices[device].Gaps = gaps
+		report.SequenceGaps += gaps
+	}
+
+	for device, counts := range report.Devices {
+		if counts.Expected == counts.Received && counts.Gaps == 0 {
+			delete(report.Devices, device)
+		}
+	}
+
+	report.LatencyMs = newPercentiles(latencies)
+	report.EventTimeLagMs = newPercentiles(lags)
+
+	return report, nil
+}
+
+// sequenceGaps returns how many numbers are missing between the lowest and the highest sequence numbers.
+// Sequence numbers may repeat, near duplicates keep the number of the original event
+func sequenceGaps(seqs []uint64) int {
+	if len(seqs) == 0 {
+		return 0
+	}
+
+	sorted := append([]uint64(nil), seqs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	gaps := 0
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] > sorted[i-1]+1 {
+			gaps += int(sorted[i] - sorted[i-1] - 1)
+		}
+	}
+
+	return gaps
+}
+
+// parseEventTime returns the time when the event happened according to the event itself
+func parseEventTime(data []byte) (time.Time, bool) {
+	var fields struct {
+		Time        json.RawMessage `json:"time"`
+		ChangeDate  json.RawMessage `json:"change_date"`
+		Data        json.RawMessage `json:"data"`
+		SpecVersion string          `json:"specversion"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return time.Time{}, false
+	}
+
+	switch {
+	case fields.SpecVersion != "": // CloudEvents keep the event time in the time attribute
+		var t time.Time
+		if err := json.Unmarshal(fields.Time, &t); err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	case len(fields.Data) > 0: // the event is in an envelope
+		return parseEventTime(fields.Data)
+	case len(fields.Time) > 0:
+		return parseTimestamp(fields.Time)
+	case len(fields.ChangeDate) > 0:
+		return parseTimestamp(fields.ChangeDate)
+	default:
+		return time.Time{}, false
+	}
+}
+
+// parseTimestamp reads a time in any of --timestamp-format formats. Precision of unix time is guessed by its magnitude,
+// local time strings are read as UTC
+func parseTimestamp(raw json.RawMessage) (time.Time, bool) {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) { // null is decoded into any type without an error
+		return time.Time{}, false
+	}
+
+	var unix int64
+	if err := json.Unmarshal(raw, &unix); err == nil {
+		switch {
+		case unix > 1e17 || unix < -1e17:
+			return time.Unix(0, unix), true
+		case unix > 1e14 || unix < -1e14:
+			return time.Unix(0, unix*int64(time.Microsecond)), true
+		case unix > 1e11 || unix < -1e11:
+			return time.Unix(0, unix*int64(time.Millisecond)), true
+		default:
+			return time.Unix(unix, 0), true
+		}
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{time.RFC3339Nano, events_generator.LocalTimestampLayout} {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func newPercentiles(values []float64) *percentiles {
+	if len(values) == 0 {
+		return nil
+	}
+
+	sort.Float64s(values)
+	at := func(p float64) float64 {
+		return values[int(p*float64(len(values)-1))]
+	}
+
+	return &percentiles{
+		P50: at(.5),
+		P90: at(.9),
+		P99: at(.99),
+		Max: values[len(values)-1],
+	}
+}
+
+func logReport(report verifyReport) {
+	l := log.WithFields(log.Fields{
+		"expected":            report.Expected,
+		"received":            report.Received,
+		"dead_lettered":       report.DeadLettered,
+		"missing":             report.Missing,
+		"duplicated":          report.Duplicated,
+		"unexpected":          report.Unexpected,
+		"sequence_gaps":       report.SequenceGaps,
+		"injected_duplicates": report.InjectedDuplicates,
+		"devices":             len(report.Devices),
+	})
+	if len(report.InjectedFaults) > 0 {
+		l = l.WithField("injected_faults", report.InjectedFaults)
+	}
+	if report.LatencyMs != nil {
+		l = l.WithField("latency_ms", *report.LatencyMs)
+	}
+	if report.EventTimeLagMs != nil {
+		l = l.WithField("event_time_lag_ms", *report.EventTimeLagMs)
+	}
+
+	if report.Missing > 0 || report.Duplicated > 0 || report.SequenceGaps > 0 {
+		l.Warnf("%s doesn't match what was published, devices field is the number of devices with wrong counts", report.Stream)
+		return
+	}
+	l.Infof("%s has everything that was published", report.Stream)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSequenceGaps(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []uint64
+		want int
+	}{
+		{"no events", nil, 0},
+		{"single event", []uint64{7}, 0},
+		{"continuous", []uint64{1, 2, 3, 4}, 0},
+		{"out of order", []uint64{3, 1, 2}, 0},
+		{"repeated numbers", []uint64{1, 2, 2, 3}, 0},
+		{"one gap", []uint64{1, 2, 4}, 1},
+		{"wide gap", []uint64{10, 1}, 8},
+		{"several gaps", []uint64{5, 1, 3, 9}, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sequenceGaps(tt.seqs); got != tt.want {
+				t.Errorf("sequenceGaps(%v) = %d, want %d", tt.seqs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	at := time.Date(2018, 10, 20, 1, 46, 40, 123456789, time.UTC)
+
+	tests := []struct {
+		name string
+		raw  string
+		want time.Time
+		ok   bool
+	}{
+		{"unix seconds", `1540000000`, time.Unix(1540000000, 0), true},
+		{"unix milliseconds", `1540000000123`, at.Truncate(time.Millisecond), true},
+		{"unix microseconds", `1540000000123456`, at.Truncate(time.Microsecond), true},
+		{"unix nanoseconds", `1540000000123456789`, at, true},
+		{"negative unix milliseconds", `-1540000000123`, time.Unix(0, -1540000000123*int64(time.Millisecond)), true},
+		{"early unix seconds", `86400`, time.Unix(86400, 0), true},
+		{"rfc3339 with zone", `"2018-10-20T05:16:40.123+03:30"`, at.Truncate(time.Millisecond), true},
+		{"rfc3339 in utc", `"2018-10-20T01:46:40Z"`, at.Truncate(time.Second), true},
+		{"local time is read as utc", `"2018-10-20 01:46:40"`, at.Truncate(time.Second), true},
+		{"unknown layout", `"20 Oct 2018"`, time.Time{}, false},
+		{"float", `1540000000.5`, time.Time{}, false},
+		{"null", `null`, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseTimestamp(json.RawMessage(tt.raw))
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("parseTimestamp(%s) = %s, %t, want %s, %t", tt.raw, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package events_generator
+
+// Behavior holds optional behaviors of devices. Devices behave the original way if it's nil or its fields are unset
+type Behavior struct {
+	// Statuses makes heartbeat devices send statuses changing by the model instead of always being UP
+	Statuses *StatusModel
+	// StoreAndForward makes heartbeat and broken temperature devices send events buffered while they were down
+	StoreAndForward *StoreAndForward
+	// Lateness replaces late events built into devices of the cases
+	Lateness map[Case]*Lateness
+	// Duplicates makes orgs emit a share of events once again
+	Duplicates *Duplicates
+	// SchemaEvolution changes schemas of events over the course of the run
+	SchemaEvolution *SchemaEvolution
+	// Clocks gives devices clocks with offsets, drift and jumps
+	Clocks *ClockModel
+	// TimestampFormat renders times of events, unix seconds if it's unset
+	TimestampFormat TimestampFormat
+}
+
+// behaving is implemented by devices which support optional behaviors
+type behaving interface {
+	setBehavior(b *Behavior)
+}
+
+// SetBehavior applies the behavior to devices of the org. It isn't a part of checkpoints
+func (org *Org) SetBehavior(b *Behavior) {
+	org.lock.Lock()
+	defer org.lock.Unlock()
+
+	org.Behavior = b
+	for _, d := range org.Devices {
+		if d, ok := d.(behaving); ok {
+			d.setBehavior(b)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package events_generator
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"time"
 
@@ -30,6 +31,10 @@ type case4Device struct {
 	case34Device
 	IsBroken bool  `json:"is_broken"`
 	LastUp   int64 `json:"last_up"`
+	// Backlog keeps readings while the device is broken when Behavior.StoreAndForward is set
+	Backlog []*temperatureReadingMessage `json:"backlog,omitempty"`
+
+	clockSkew int64
 }
 
 func generateCase4Devices(orgId string, n int, debugEvents bool) []device {
@@ -55,6 +60,21 @@ func (d *case4Device) String() string {
 		d.case34Device.String(), d.IsBroken, d.LastUp)
 }
 
+func (d *case4Device) States() []string {
+	var states []string
+	if d.IsBroken {
+		states = []string{"broken"}
+	} else {
+		states = append([]string{"up"}, d.case34Device.States()...)
+	}
+
+	if len(d.Backlog) > 0 {
+		states = append(states, "backlog")
+	}
+
+	return states
+}
+
 func (d *case4Device) Generate() Event {
 	now := time.Now().Unix()
 
@@ -63,6 +83,7 @@ func (d *case4Device) Generate() Event {
 			log.Infof("%d: d %s/%d is broken", now, d.OrgId, d.DeviceId)
 		}
 		case4BrokenDevice.WithLabelValues(d.OrgId).Inc()
+		d.store()
 		return nil
 	} else if d.IsBroken {
 		if d.DebugEvents {
@@ -80,9 +101,61 @@ func (d *case4Device) Generate() Event {
 		}
 		d.IsBroken = true
 		case4BrokenDevice.WithLabelValues(d.OrgId).Inc()
+		d.store()
 		return nil
 	} else {
 		d.LastUp = now
 		return d.case34Device.Generate()
 	}
 }
+
+// store takes a reading into the buffer if the device stores and forwards events. Buffered readings are taken
+// around the mean, they are neither late nor spikes
+func (d *case4Device) store() {
+	s := storeAndForward(d.behavior)
+	if s == nil {
+		return
+	}
+
+	mean := float64(d.SumTemperature) / float64(d.CountMeasurements)
+	storedAt := time.Now()
+	reading := &temperatureReadingMessage{
+		deviceMessage: deviceMessage{
+			DeviceId: d.DeviceId,
+			Time:     storedAt.Unix() + d.clockSkew,
+			nanos:    int64(storedAt.Nanosecond()),
+			storedAt: storedAt,
+		},
+		DeviceName:  d.DeviceName,
+		Temperature: int(math.Round(rand.NormFloat64()*.5 + mean)),
+	}
+
+	d.Backlog = append(d.Backlog, reading)
+	bufferedEvents.WithLabelValues(d.OrgId, string(d.Case)).Inc()
+	if n := s.overflow(len(d.Backlog)); n > 0 {
+		d.Backlog = d.Backlog[n:]
+		droppedBufferedEvents.WithLabelValues(d.OrgId, string(d.Case)).Add(float64(n))
+	}
+}
+
+func (d *case4Device) setClockSkew(seconds int64) {
+	d.clockSkew = seconds
+}
+
+func (d *case4Device) forward() []Event {
+	if d.IsBroken || len(d.Backlog) == 0 {
+		return nil
+	}
+
+	events := make([]Event, 0, len(d.Backlog))
+	for _, reading := range d.Backlog {
+		events = append(events, reading)
+	}
+	if d.DebugEvents {
+		log.Printf("d %s/%d forwards %d buffered readings", d.OrgId, d.DeviceId, len(events))
+	}
+	forwardedEvents.WithLabelValues(d.OrgId, string(d.Case)).Add(float64(len(events)))
+	d.Backlog = nil
+
+	return events
+}
@@ -0,0 +1,103 @@
+package events_generator
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// OrgCheckpoint is the state of an org and all its devices
+type OrgCheckpoint struct {
+	OrgId         string          `json:"org_id"`
+	OrgSize       OrgSize         `json:"org_size"`
+	CaseId        Case            `json:"case"`
+	KinesisPrefix string          `json:"kinesis_prefix"`
+	Devices       json.RawMessage `json:"devices"`
+	// Sequences are numbers of the last events of devices if the org numbers them
+	Sequences []uint64 `json:"sequences,omitempty"`
+	// Clocks are clocks of devices if they have their own ones
+	Clocks []DeviceClock `json:"clocks,omitempty"`
+}
+
+// Checkpoint captures state of the org between generation cycles
+func (org *Org) Checkpoint() (OrgCheckpoint, error) {
+	org.lock.Lock()
+	defer org.lock.Unlock()
+
+	devices, err := json.Marshal(org.Devices)
+	if err != nil {
+		return OrgCheckpoint{}, fmt.Errorf("can't serialize devices of org %s of case %s: %s", org.OrgId, org.CaseId, err)
+	}
+
+	return OrgCheckpoint{
+		OrgId:         org.OrgId,
+		OrgSize:       org.OrgSize,
+		CaseId:        org.CaseId,
+		KinesisPrefix: org.KinesisPrefix,
+		Devices:       devices,
+		Sequences:     append([]uint64(nil), org.sequences...),
+		Clocks:        append([]DeviceClock(nil), org.clocks...),
+	}, nil
+}
+
+// RestoreOrg recreates the org with devices in the same state they were when the checkpoint was taken
+func RestoreOrg(checkpoint OrgCheckpoint, debugEvents bool, prefix string) (*Org, error) {
+	var devices []device
+	var err error
+
+	switch checkpoint.CaseId {
+	case CaseOne:
+		var restored []*case1Device
+		err = json.Unmarshal(checkpoint.Devices, &restored)
+		for _, d := range restored {
+			d.DebugEvents = debugEvents
+			devices = append(devices, d)
+		}
+	case CaseTwo:
+		var restored []*case2Device
+		err = json.Unmarshal(checkpoint.Devices, &restored)
+		for _, d := range restored {
+			d.DebugEvents = debugEvents
+			devices = append(devices, d)
+		}
+	case CaseThree:
+		var restored []*case34Device
+		err = json.Unmarshal(checkpoint.Devices, &restored)
+		for _, d := range restored {
+			d.DebugEvents = debugEvents
+			devices = append(devices, d)
+		}
+	case CaseFour:
+		var restored []*case4Device
+		err = json.Unmarshal(checkpoint.Devices, &restored)
+		for _, d := range restored {
+			d.DebugEvents = debugEvents
+			devices = append(devices, d)
+		}
+	case CaseFive:
+		var restored []*case5
+		err = json.Unmarshal(checkpoint.Devices, &restored)
+		for _, d := range restored {
+			d.DebugEvents = debugEvents
+			devices = append(devices, d)
+		}
+	default:
+		return nil, fmt.Errorf("org %s has unknown case %s", checkpoint.OrgId, checkpoint.CaseId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't restore devices of org %s of case %s: %s", checkpoint.OrgId, checkpoint.CaseId, err)
+	}
+
+	numberOfDevices.WithLabelValues(checkpoint.OrgId, string(checkpoint.CaseId)).Set(float64(len(devices)))
+
+	return &Org{
+		OrgId:         checkpoint.OrgId,
+		OrgSize:       checkpoint.OrgSize,
+		CaseId:        checkpoint.CaseId,
+		GlobalPrefix:  prefix,
+		KinesisPrefix: checkpoint.KinesisPrefix,
+		Devices:       devices,
+		DebugEvents:   debugEvents,
+		sequences:     checkpoint.Sequences,
+		clocks:        checkpoint.Clocks,
+	}, nil
+}
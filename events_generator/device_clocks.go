@@ -0,0 +1,107 @@
+package events_generator
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/melan/gen-events/misc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var clockJumps = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: misc.MetricsPrefix,
+		Name:      "clock_jumps",
+		Help:      "Number of jumps of clocks of devices by the kind: ntp or epoch",
+	},
+	[]string{"orgId", "caseId", "kind"})
+
+// ClockModel gives devices their own clocks which times of events come from. Offsets and drift rates of devices are
+// normally distributed around 0 with the standard deviations
+type ClockModel struct {
+	OffsetStdDevSec float64
+	DriftStdDevPpm  float64
+	// NtpRate is the share of devices which correct their clocks in a cycle
+	NtpRate float64
+	// EpochResetRate is the share of devices which clocks are reset to the unix epoch in a cycle
+	EpochResetRate float64
+}
+
+// DeviceClock is the clock of a device. Time of the device is the real time plus the offset plus the drift
+// accumulated since the clock was synced, or the time since the reset if the clock was reset to the epoch
+type DeviceClock struct {
+	OffsetSec float64 `json:"offset_sec"`
+	DriftPpm  float64 `json:"drift_ppm"`
+	// SyncedAt is unix time when the offset was set
+	SyncedAt int64 `json:"synced_at"`
+	// ResetAt is unix time when the clock was reset to the epoch, 0 if it wasn't
+	ResetAt int64 `json:"reset_at,omitempty"`
+}
+
+// skew returns seconds between the time of the clock and the real time
+func (c *DeviceClock) skew(now int64) int64 {
+	if c.ResetAt > 0 {
+		return -c.ResetAt
+	}
+
+	drift := float64(now-c.SyncedAt) * c.DriftPpm / 1e6
+	return int64(math.Round(c.OffsetSec + drift))
+}
+
+// clocked is implemented by devices which buffer events, so buffered events get the skew of the clock at the time
+// they were stored. The skew is set before every cycle
+type clocked interface {
+	setClockSkew(seconds int64)
+}
+
+func (m *ClockModel) newClock(now int64) DeviceClock {
+	return DeviceClock{
+		OffsetSec: rand.NormFloat64() * m.OffsetStdDevSec,
+		DriftPpm:  rand.NormFloat64() * m.DriftStdDevPpm,
+		SyncedAt:  now,
+	}
+}
+
+func clockModel(b *Behavior) *ClockModel {
+	if b == nil {
+		return nil
+	}
+
+	return b.Clocks
+}
+
+// clockSkew moves the clock of the i-th device to the cycle and returns its skew. It has to be called under the org lock
+func (org *Org) clockSkew(i int, now int64) int64 {
+	m := clockModel(org.Behavior)
+	if m == nil {
+		return 0
+	}
+
+	if len(org.clocks) != len(org.Devices) {
+		clocks := make([]DeviceClock, len(org.Devices))
+		for j := range clocks {
+			if j < len(org.clocks) {
+				clocks[j] = org.clocks[j]
+			} else {
+				clocks[j] = m.newClock(now)
+			}
+		}
+		org.clocks = clocks
+	}
+
+	c := &org.clocks[i]
+	chance := rand.Float64()
+	switch {
+	case chance < m.NtpRate: // the clock is synced with a small error and drifts away from now on
+		c.OffsetSec = rand.NormFloat64() * .05
+		c.SyncedAt = now
+		c.ResetAt = 0
+		clockJumps.WithLabelValues(org.OrgId, string(org.CaseId), "ntp").Inc()
+	case chance < m.NtpRate+m.EpochResetRate && c.ResetAt == 0:
+		c.ResetAt = now
+		clockJumps.WithLabelValues(org.OrgId, string(org.CaseId), "epoch").Inc()
+	}
+
+	return c.skew(now)
+}
@@ -0,0 +1,108 @@
+package events_generator
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DeviceState is a snapshot of a device. Device holds all fields of the device serialized to json
+type DeviceState struct {
+	Key    string          `json:"key"`
+	States []string        `json:"states"`
+	Device json.RawMessage `json:"device"`
+}
+
+// DeviceHistoryEntry describes a device after a generation cycle
+type DeviceHistoryEntry struct {
+	Time    int64    `json:"time"`
+	States  []string `json:"states"`
+	Emitted bool     `json:"emitted"`
+}
+
+// DeviceStates returns snapshots of devices which are in the state, or of all devices if the state is empty,
+// skipping the first offset of them. It also returns the number of matching devices
+func (org *Org) DeviceStates(state string, offset int, limit int) ([]DeviceState, int, error) {
+	org.lock.Lock()
+	defer org.lock.Unlock()
+
+	states := make([]DeviceState, 0)
+	total := 0
+	for _, d := range org.Devices {
+		if state != "" && !hasState(d, state) {
+			continue
+		}
+
+		total++
+		if total <= offset || len(states) >= limit {
+			continue
+		}
+
+		deviceState, err := snapshot(d)
+		if err != nil {
+			return nil, 0, err
+		}
+		states = append(states, deviceState)
+	}
+
+	return states, total, nil
+}
+
+// DeviceState returns a snapshot of the device and its history, the oldest entries first. ok is false if the org has
+// no device with the key
+func (org *Org) DeviceState(key string) (state DeviceState, history []DeviceHistoryEntry, ok bool, err error) {
+	org.lock.Lock()
+	defer org.lock.Unlock()
+
+	if org.deviceIndex == nil {
+		org.deviceIndex = make(map[string]int, len(org.Devices))
+		for i, d := range org.Devices {
+			org.deviceIndex[d.Key()] = i
+		}
+	}
+
+	i, ok := org.deviceIndex[key]
+	if !ok {
+		return DeviceState{}, nil, false, nil
+	}
+
+	state, err = snapshot(org.Devices[i])
+	if err != nil {
+		return DeviceState{}, nil, true, err
+	}
+
+	history = make([]DeviceHistoryEntry, len(org.history[i]))
+	copy(history, org.history[i])
+
+	return state, history, true, nil
+}
+
+func (org *Org) recordHistory(i int, entry DeviceHistoryEntry) {
+	if org.history == nil {
+		org.history = make(map[int][]DeviceHistoryEntry, len(org.Devices))
+	}
+
+	history := org.history[i]
+	if len(history) >= org.HistorySize {
+		history = history[len(history)-org.HistorySize+1:]
+	}
+	org.history[i] = append(history, entry)
+}
+
+func snapshot(d device) (DeviceState, error) {
+	js, err := json.Marshal(d)
+	if err != nil {
+		return DeviceState{}, fmt.Errorf("can't serialize device %s: %s", d.Key(), err)
+	}
+
+	return DeviceState{Key: d.Key(), States: d.States(), Device: js}, nil
+}
+
+func hasState(d device, state string) bool {
+	for _, s := range d.States() {
+		if s == state {
+			return true
+		}
+	}
+
+	return false
+}
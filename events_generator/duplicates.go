@@ -0,0 +1,190 @@
+package events_generator
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/melan/gen-events/misc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var injectedDuplicates = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: misc.MetricsPrefix,
+		Name:      "injected_duplicates",
+		Help:      "Number of duplicates of events injected by the kind",
+	},
+	[]string{"orgId", "caseId", "kind"})
+
+type DuplicateKind string
+
+const (
+	// ExactDuplicate is the same event emitted again in the same cycle
+	ExactDuplicate DuplicateKind = "exact"
+	// DelayedDuplicate is the same event emitted again in a later cycle
+	DelayedDuplicate DuplicateKind = "delayed"
+	// NearDuplicate has the same device, time and sequence number as the event but a different payload
+	NearDuplicate DuplicateKind = "near"
+)
+
+// Duplicates makes orgs emit a share of events once again, the ratios add up to 1 at most
+type Duplicates struct {
+	ExactRatio   float64
+	DelayedRatio float64
+	NearRatio    float64
+	// MaxDelayCycles is the largest number of cycles delayed duplicates come after their events
+	MaxDelayCycles int
+}
+
+type duplicateEvent struct {
+	Event
+	kind DuplicateKind
+}
+
+func (e *duplicateEvent) Unwrap() Event {
+	return e.Event
+}
+
+// DuplicateOf returns the kind of the duplicate if the event was injected as a duplicate of another one
+func DuplicateOf(e Event) (DuplicateKind, bool) {
+	found := FindEvent(e, func(e Event) bool {
+		_, ok := e.(*duplicateEvent)
+		return ok
+	})
+	if found == nil {
+		return "", false
+	}
+
+	return found.(*duplicateEvent).kind, true
+}
+
+type delayedDuplicate struct {
+	due   uint64
+	event Event
+}
+
+// nearDuplicating is implemented by messages which can make a copy with a different payload
+type nearDuplicating interface {
+	nearDuplicate() Event
+}
+
+func (hbm *heartbeatMessage) nearDuplicate() Event {
+	near := *hbm
+	for near.Status == hbm.Status {
+		near.Status = AllStatuses[rand.Intn(len(AllStatuses))]
+	}
+
+	return &near
+}
+
+func (m *temperatureReadingMessage) nearDuplicate() Event {
+	near := *m
+	near.Temperature += 1 - 2*rand.Intn(2)
+
+	return &near
+}
+
+func (nem *noisyErrorMessage) nearDuplicate() Event {
+	near := *nem
+	for near.ErrorType == nem.ErrorType {
+		near.ErrorType = allCase2Errors[rand.Intn(len(allCase2Errors))]
+	}
+	near.ErrorMessage = generateErrorMessage()
+
+	return &near
+}
+
+func (m *randomChangeMessage) nearDuplicate() Event {
+	near := *m
+	near.CustomerRating += float32(rand.NormFloat64())
+
+	return &near
+}
+
+func duplicates(b *Behavior) *Duplicates {
+	if b == nil {
+		return nil
+	}
+
+	return b.Duplicates
+}
+
+// injectDuplicates adds duplicates of the events and delayed duplicates which are due to the events of the cycle.
+// Delayed duplicates aren't a part of checkpoints. It has to be called under the org lock
+func (org *Org) injectDuplicates(events []Event, cycleTime time.Time) []Event {
+	d := duplicates(org.Behavior)
+	if d == nil {
+		org.delayedDuplicates = nil
+		return events
+	}
+	org.cycle++
+
+	pending := org.delayedDuplicates[:0]
+	for _, delayed := range org.delayedDuplicates {
+		if delayed.due > org.cycle {
+			pending = append(pending, delayed)
+			continue
+		}
+		events = append(events, org.duplicate(delayed.event, DelayedDuplicate))
+	}
+	org.delayedDuplicates = pending
+
+	maxDelay := d.MaxDelayCycles
+	if maxDelay < 1 {
+		maxDelay = 1
+	}
+
+	var devices map[string]int // built for the first near duplicate
+	for _, e := range events {
+		if _, ok := e.(*duplicateEvent); ok {
+			continue
+		}
+
+		chance := rand.Float64()
+		switch {
+		case chance < d.ExactRatio:
+			events = append(events, org.duplicate(e, ExactDuplicate))
+		case chance < d.ExactRatio+d.NearRatio:
+			if devices == nil {
+				devices = make(map[string]int, len(org.Devices))
+				for i, device := range org.Devices {
+					devices[device.Key()] = i
+				}
+			}
+			if near := org.nearDuplicate(e, devices, cycleTime); near != nil {
+				events = append(events, org.duplicate(near, NearDuplicate))
+			}
+		case chance < d.ExactRatio+d.NearRatio+d.DelayedRatio:
+			due := org.cycle + 1 + uint64(rand.Intn(maxDelay))
+			org.delayedDuplicates = append(org.delayedDuplicates, delayedDuplicate{due: due, event: e})
+		}
+	}
+
+	return events
+}
+
+func (org *Org) duplicate(e Event, kind DuplicateKind) Event {
+	injectedDuplicates.WithLabelValues(org.OrgId, string(org.CaseId), string(kind)).Inc()
+	return &duplicateEvent{Event: e, kind: kind}
+}
+
+// nearDuplicate copies the message of the event with a different payload and decorates it like the event, keeping its
+// sequence number. devices are indexes of devices by their keys
+func (org *Org) nearDuplicate(e Event, devices map[string]int, cycleTime time.Time) Event {
+	found := FindEvent(e, func(e Event) bool {
+		_, ok := e.(nearDuplicating)
+		return ok
+	})
+	if found == nil {
+		return nil
+	}
+
+	i, ok := devices[DeviceKey(e)]
+	if !ok {
+		return nil
+	}
+	seq, _ := Sequence(e)
+
+	return org.decorate(org.Devices[i], i, found.(nearDuplicating).nearDuplicate(), cycleTime, seq)
+}
@@ -0,0 +1,64 @@
+package events_generator
+
+import "time"
+
+// SequencedEvent carries the number of the event among events of its device, starting from 1
+type SequencedEvent interface {
+	Event
+	Sequence() uint64
+}
+
+type sequencedEvent struct {
+	Event
+	seq uint64
+}
+
+func (e *sequencedEvent) Unwrap() Event {
+	return e.Event
+}
+
+func (e *sequencedEvent) Sequence() uint64 {
+	return e.seq
+}
+
+// Sequence returns the number of the event among events of its device if the org numbers them
+func Sequence(e Event) (uint64, bool) {
+	found := FindEvent(e, func(e Event) bool {
+		_, ok := e.(SequencedEvent)
+		return ok
+	})
+	if found == nil {
+		return 0, false
+	}
+
+	return found.(SequencedEvent).Sequence(), true
+}
+
+// TimedEvent knows when it happened according to the device
+type TimedEvent interface {
+	Event
+	EventTime() time.Time
+}
+
+// EventTime returns the time of the event if it's known
+func EventTime(e Event) (time.Time, bool) {
+	found := FindEvent(e, func(e Event) bool {
+		_, ok := e.(TimedEvent)
+		return ok
+	})
+	if found == nil {
+		return time.Time{}, false
+	}
+
+	return found.(TimedEvent).EventTime(), true
+}
+
+// sequence numbers the event of the i-th device. It has to be called under the org lock
+func (org *Org) sequence(i int, e Event) Event {
+	if len(org.sequences) != len(org.Devices) {
+		org.sequences = make([]uint64, len(org.Devices))
+	}
+	org.sequences[i]++
+
+	return &sequencedEvent{Event: e, seq: org.sequences[i]}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/melan/gen-events/misc"
@@ -56,6 +58,13 @@ var (
 			Name:      "case1_up_devices",
 		},
 		[]string{"orgId"})
+	case1StatusChanges = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: misc.MetricsPrefix,
+			Name:      "case1_status_changes",
+			Help:      "Number of devices which changed their explicit status to the status",
+		},
+		[]string{"orgId", "status"})
 )
 
 type heartbeatMessage struct {
@@ -76,6 +85,13 @@ type case1Device struct {
 	IsLongDown          bool    `json:"isLongDown"`
 	Quality             float64 `json:"quality"`
 	DebugEvents         bool    `json:"debug_events"`
+	// Status is the explicit status of the device when Behavior.Statuses is set
+	Status string `json:"status,omitempty"`
+	// Backlog keeps heartbeats while the device is long down when Behavior.StoreAndForward is set
+	Backlog []*heartbeatMessage `json:"backlog,omitempty"`
+
+	behavior  *Behavior
+	clockSkew int64
 }
 
 func generateCase1Devices(orgId string, n int, stdDev float64, debugEvents bool) []device {
@@ -125,10 +141,70 @@ func (cod *case1Device) String() string {
 		cod.OrgId, cod.DeviceId, cod.Quality, cod.ProbabilityDown, cod.ProbabilityLongDown, cod.LastUp, cod.IsLongDown)
 }
 
+func (cod *case1Device) setBehavior(b *Behavior) {
+	cod.behavior = b
+}
+
+func (cod *case1Device) Key() string {
+	return strconv.Itoa(cod.DeviceId)
+}
+
+func (cod *case1Device) States() []string {
+	var states []string
+	switch {
+	case cod.LastUp == -1:
+		states = []string{"new"}
+	case cod.IsLongDown:
+		states = []string{"long_down"}
+	default:
+		states = []string{"up"}
+	}
+
+	if cod.Status != "" {
+		states = append(states, "status_"+strings.ToLower(cod.Status))
+	}
+	if len(cod.Backlog) > 0 {
+		states = append(states, "backlog")
+	}
+
+	return states
+}
+
+// status returns the status to send. The explicit status changes every cycle the device is up if the behavior has
+// the status model, heartbeats kept while the device is down have the status it had when it was up last time
+func (cod *case1Device) status(now int64) string {
+	if cod.behavior == nil || cod.behavior.Statuses == nil {
+		return StatusUp
+	}
+
+	if cod.Status == "" {
+		cod.Status = StatusUp
+	} else if next := cod.behavior.Statuses.Next(cod.Status, rand.Float64()); next != cod.Status {
+		if cod.DebugEvents {
+			log.Printf("%d: d %s/%d changes status from %s to %s", now, cod.OrgId, cod.DeviceId, cod.Status, next)
+		}
+		case1StatusChanges.WithLabelValues(cod.OrgId, next).Inc()
+		cod.Status = next
+	}
+
+	return cod.Status
+}
+
+// lastStatus is the status of the device when it was up last time
+func (cod *case1Device) lastStatus() string {
+	if cod.Status == "" {
+		return StatusUp
+	}
+
+	return cod.Status
+}
+
 func (cod *case1Device) Generate() Event {
-	now := time.Now().Unix()
+	generatedAt := time.Now()
+	now := generatedAt.Unix()
 
 	if cod.LastUp == -1 {
+		status := cod.status(now)
 		if cod.DebugEvents {
 			log.Printf("%d: d %s/%d first event", now, cod.OrgId, cod.DeviceId)
 		}
@@ -138,8 +214,9 @@ func (cod *case1Device) Generate() Event {
 			deviceMessage: deviceMessage{
 				DeviceId: cod.DeviceId,
 				Time:     now,
+				nanos:    int64(generatedAt.Nanosecond()),
 			},
-			Status: "UP",
+			Status: status,
 		}
 	}
 
@@ -148,8 +225,17 @@ func (cod *case1Device) Generate() Event {
 			log.Printf("%d: d %s/%d is long down", now, cod.OrgId, cod.DeviceId)
 		}
 		case1NumberOfLongDown.WithLabelValues(cod.OrgId).Inc()
+		cod.store(&heartbeatMessage{
+			deviceMessage: deviceMessage{
+				DeviceId: cod.DeviceId,
+				Time:     now,
+				nanos:    int64(generatedAt.Nanosecond()),
+			},
+			Status: cod.lastStatus(),
+		})
 		return nil
 	} else if cod.IsLongDown {
+		status := cod.status(now)
 		cod.IsLongDown = false
 		cod.LastUp = now
 		if cod.DebugEvents {
@@ -160,18 +246,20 @@ func (cod *case1Device) Generate() Event {
 			deviceMessage: deviceMessage{
 				DeviceId: cod.DeviceId,
 				Time:     now,
+				nanos:    int64(generatedAt.Nanosecond()),
 			},
-			Status: "UP",
+			Status: status,
 		}
 	}
 
 	cod.LastUp = now
 
-	if chance := rand.Float64(); chance < .01 { // send late message
+	if chance := rand.Float64(); chance < .01 && lateness(cod.behavior, CaseOne) == nil { // send late message
 		newNow := now - (10+rand.Int63n(10))*60
 		if chance < .005 {
+			status := cod.status(now)
 			if cod.DebugEvents {
-				log.Printf("%d: d %s/%d is late and %s", newNow, cod.OrgId, cod.DeviceId, "UP")
+				log.Printf("%d: d %s/%d is late and %s", newNow, cod.OrgId, cod.DeviceId, status)
 			}
 			case1LateUp.WithLabelValues(cod.OrgId).Inc()
 
@@ -179,8 +267,9 @@ func (cod *case1Device) Generate() Event {
 				deviceMessage: deviceMessage{
 					DeviceId: cod.DeviceId,
 					Time:     newNow, // send the event back to 10-20 minutes
+					nanos:    int64(generatedAt.Nanosecond()),
 				},
-				Status: "UP",
+				Status: status,
 			}
 		} else {
 			if cod.DebugEvents {
@@ -205,18 +294,67 @@ func (cod *case1Device) Generate() Event {
 		}
 		case1EnteredFromLongDown.WithLabelValues(cod.OrgId).Inc()
 		cod.IsLongDown = true
+		cod.store(&heartbeatMessage{
+			deviceMessage: deviceMessage{
+				DeviceId: cod.DeviceId,
+				Time:     now,
+				nanos:    int64(generatedAt.Nanosecond()),
+			},
+			Status: cod.lastStatus(),
+		})
 		return nil
 	}
 
+	status := cod.status(now)
 	if cod.DebugEvents {
-		log.Printf("%d: d %s/%d is UP", now, cod.OrgId, cod.DeviceId)
+		log.Printf("%d: d %s/%d is %s", now, cod.OrgId, cod.DeviceId, status)
 	}
 	case1Up.WithLabelValues(cod.OrgId).Inc()
 	return &heartbeatMessage{
 		deviceMessage: deviceMessage{
 			DeviceId: cod.DeviceId,
 			Time:     now,
+			nanos:    int64(generatedAt.Nanosecond()),
 		},
-		Status: "UP",
+		Status: status,
+	}
+}
+
+// store buffers the heartbeat if the device stores and forwards events
+func (cod *case1Device) store(hbm *heartbeatMessage) {
+	s := storeAndForward(cod.behavior)
+	if s == nil {
+		return
 	}
+
+	hbm.shiftTime(cod.clockSkew)
+	hbm.storedAt = time.Now()
+	cod.Backlog = append(cod.Backlog, hbm)
+	bufferedEvents.WithLabelValues(cod.OrgId, string(CaseOne)).Inc()
+	if n := s.overflow(len(cod.Backlog)); n > 0 {
+		cod.Backlog = cod.Backlog[n:]
+		droppedBufferedEvents.WithLabelValues(cod.OrgId, string(CaseOne)).Add(float64(n))
+	}
+}
+
+func (cod *case1Device) setClockSkew(seconds int64) {
+	cod.clockSkew = seconds
+}
+
+func (cod *case1Device) forward() []Event {
+	if cod.IsLongDown || len(cod.Backlog) == 0 {
+		return nil
+	}
+
+	events := make([]Event, 0, len(cod.Backlog))
+	for _, hbm := range cod.Backlog {
+		events = append(events, hbm)
+	}
+	if cod.DebugEvents {
+		log.Printf("d %s/%d forwards %d buffered heartbeats", cod.OrgId, cod.DeviceId, len(events))
+	}
+	forwardedEvents.WithLabelValues(cod.OrgId, string(CaseOne)).Add(float64(len(events)))
+	cod.Backlog = nil
+
+	return events
 }
@@ -0,0 +1,62 @@
+package events_generator
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCase1DeviceStatusWhileLongDown(t *testing.T) {
+	behavior := &Behavior{
+		// the status changes every cycle the device is up
+		Statuses: &StatusModel{Transitions: map[string]map[string]float64{
+			StatusUp:   {StatusDown: 1},
+			StatusDown: {StatusUp: 1},
+		}},
+		StoreAndForward: &StoreAndForward{},
+	}
+
+	tests := []struct {
+		name        string
+		downFor     time.Duration
+		cycles      int
+		wantBacklog []string
+		wantEvent   string
+	}{
+		{"long down keeps the last status", time.Minute, 3, []string{StatusUp, StatusUp, StatusUp}, ""},
+		{"return changes the status once", 21 * time.Minute, 1, nil, StatusDown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cod := &case1Device{
+				OrgId:      "1",
+				LastUp:     time.Now().Add(-tt.downFor).Unix(),
+				IsLongDown: true,
+				Status:     StatusUp,
+				behavior:   behavior,
+			}
+
+			var event Event
+			for i := 0; i < tt.cycles; i++ {
+				event = cod.Generate()
+			}
+
+			var backlog []string
+			for _, hbm := range cod.Backlog {
+				backlog = append(backlog, hbm.Status)
+			}
+			if !reflect.DeepEqual(backlog, tt.wantBacklog) {
+				t.Errorf("backlog has statuses %v, want %v", backlog, tt.wantBacklog)
+			}
+
+			var status string
+			if event != nil {
+				status = event.(*heartbeatMessage).Status
+			}
+			if status != tt.wantEvent {
+				t.Errorf("event has status %q, want %q", status, tt.wantEvent)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package events_generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// jsonField is a field of a serialized json object with its serialized value
+type jsonField struct {
+	name  string
+	value json.RawMessage
+}
+
+// splitJsonObject returns fields of the serialized json object in the order they are in the object
+func splitJsonObject(js []byte) ([]jsonField, error) {
+	decoder := json.NewDecoder(bytes.NewReader(js))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("%s isn't a json object", js)
+	}
+
+	fields := make([]jsonField, 0)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, jsonField{name: token.(string), value: value})
+	}
+
+	return fields, nil
+}
+
+// joinJsonObject serializes the fields into a json object keeping their order
+func joinJsonObject(fields []jsonField) ([]byte, error) {
+	js := []byte{'{'}
+	for i, field := range fields {
+		if i > 0 {
+			js = append(js, ',')
+		}
+		name, err := json.Marshal(field.name)
+		if err != nil {
+			return nil, err
+		}
+		js = append(js, name...)
+		js = append(js, ':')
+		js = append(js, field.value...)
+	}
+
+	return append(js, '}'), nil
+}
+
+func fieldIndex(fields []jsonField, name string) int {
+	for i, field := range fields {
+		if field.name == name {
+			return i
+		}
+	}
+
+	return -1
+}
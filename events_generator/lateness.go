@@ -0,0 +1,199 @@
+package events_generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+
+	"github.com/melan/gen-events/misc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	lateEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: misc.MetricsPrefix,
+			Name:      "late_events",
+			Help:      "Number of events dated into the past by the lateness of the case",
+		},
+		[]string{"orgId", "caseId"})
+
+	futureEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: misc.MetricsPrefix,
+			Name:      "future_events",
+			Help:      "Number of events dated into the future by the lateness of the case",
+		},
+		[]string{"orgId", "caseId"})
+)
+
+type LatenessDistribution string
+
+const (
+	FixedLateness       LatenessDistribution = "fixed"
+	UniformLateness     LatenessDistribution = "uniform"
+	ExponentialLateness LatenessDistribution = "exponential"
+	ParetoLateness      LatenessDistribution = "pareto"
+)
+
+// Lateness dates a share of events of a case into the past by a random delay. Delays are:
+//
+//	fixed - MinSec
+//	uniform - between MinSec and MaxSec
+//	exponential - MinSec plus an exponentially distributed delay with mean MeanSec
+//	pareto - Pareto distributed with scale MinSec and Shape, so a few events are very late
+//
+// MaxSec caps delays of exponential distribution if it's positive and of pareto distribution, which requires it.
+// Another share of events is dated up to FutureMaxSec into the future
+type Lateness struct {
+	Distribution LatenessDistribution `json:"distribution"`
+	Ratio        float64              `json:"ratio"`
+	MinSec       float64              `json:"min_sec"`
+	MaxSec       float64              `json:"max_sec"`
+	MeanSec      float64              `json:"mean_sec"`
+	Shape        float64              `json:"shape"`
+	FutureRatio  float64              `json:"future_ratio"`
+	FutureMaxSec float64              `json:"future_max_sec"`
+}
+
+// LoadLateness reads lateness of cases from a JSON file like
+// {"heartbeat_message": {"distribution": "pareto", "ratio": 0.05, "min_sec": 60, "shape": 1.5, "max_sec": 86400}}
+func LoadLateness(fileName string) (map[Case]*Lateness, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("can't read lateness %s: %s", fileName, err)
+	}
+
+	var lateness map[Case]*Lateness
+	if err := json.Unmarshal(content, &lateness); err != nil {
+		return nil, fmt.Errorf("can't parse lateness %s: %s", fileName, err)
+	}
+	for caseId, l := range lateness {
+		switch caseId {
+		case CaseOne, CaseTwo, CaseThree, CaseFour, CaseFive:
+		default:
+			return nil, fmt.Errorf("unknown case %q in lateness %s", caseId, fileName)
+		}
+		if l == nil {
+			return nil, fmt.Errorf("lateness of %s in %s is empty", caseId, fileName)
+		}
+		if err := l.validate(); err != nil {
+			return nil, fmt.Errorf("invalid lateness of %s in %s: %s", caseId, fileName, err)
+		}
+	}
+
+	return lateness, nil
+}
+
+func (l *Lateness) validate() error {
+	if l.Ratio < 0 || l.FutureRatio < 0 || l.Ratio+l.FutureRatio > 1 {
+		return fmt.Errorf("ratio and future_ratio have to be positive and add up to 1 at most")
+	}
+	if l.MinSec < 0 || l.MaxSec < 0 || l.FutureMaxSec < 0 {
+		return fmt.Errorf("min_sec, max_sec and future_max_sec have to be positive")
+	}
+
+	switch l.Distribution {
+	case FixedLateness:
+	case UniformLateness:
+		if l.MaxSec < l.MinSec {
+			return fmt.Errorf("max_sec has to be greater than min_sec")
+		}
+	case ExponentialLateness:
+		if l.MeanSec <= 0 {
+			return fmt.Errorf("mean_sec has to be positive")
+		}
+	case ParetoLateness:
+		if l.MinSec <= 0 || l.Shape <= 0 {
+			return fmt.Errorf("min_sec and shape have to be positive")
+		}
+		if l.MaxSec < l.MinSec { // the tail of the distribution is unbounded
+			return fmt.Errorf("max_sec has to be set and greater than min_sec")
+		}
+	default:
+		return fmt.Errorf("unknown distribution %q", l.Distribution)
+	}
+
+	return nil
+}
+
+// shift returns seconds to move the time of an event by, negative for late events
+func (l *Lateness) shift() int64 {
+	chance := rand.Float64()
+	switch {
+	case chance < l.Ratio:
+		return -int64(math.Round(l.delay()))
+	case chance < l.Ratio+l.FutureRatio:
+		return int64(math.Round(rand.Float64() * l.FutureMaxSec))
+	default:
+		return 0
+	}
+}
+
+func (l *Lateness) delay() float64 {
+	var delay float64
+	switch l.Distribution {
+	case UniformLateness:
+		return l.MinSec + rand.Float64()*(l.MaxSec-l.MinSec)
+	case ExponentialLateness:
+		delay = l.MinSec + rand.ExpFloat64()*l.MeanSec
+	case ParetoLateness:
+		delay = l.MinSec / math.Pow(1-rand.Float64(), 1/l.Shape)
+	default:
+		return l.MinSec
+	}
+
+	if l.MaxSec > 0 && delay > l.MaxSec {
+		return l.MaxSec
+	}
+
+	return delay
+}
+
+// retimable is implemented by events which time can be moved
+type retimable interface {
+	shiftTime(seconds int64)
+}
+
+func (m *deviceMessage) shiftTime(seconds int64) {
+	m.Time += seconds
+}
+
+func (m *randomChangeMessage) shiftTime(seconds int64) {
+	m.ChangeDate += seconds
+}
+
+func lateness(b *Behavior, caseId Case) *Lateness {
+	if b == nil {
+		return nil
+	}
+
+	return b.Lateness[caseId]
+}
+
+// applyLateness moves the time of the event by the lateness of the case of the org
+func (org *Org) applyLateness(e Event) {
+	l := lateness(org.Behavior, org.CaseId)
+	if l == nil {
+		return
+	}
+
+	event, ok := e.(retimable)
+	if !ok {
+		return
+	}
+
+	shift := l.shift()
+	switch {
+	case shift < 0:
+		lateEvents.WithLabelValues(org.OrgId, string(org.CaseId)).Inc()
+	case shift > 0:
+		futureEvents.WithLabelValues(org.OrgId, string(org.CaseId)).Inc()
+	default:
+		return
+	}
+	event.shiftTime(shift)
+}
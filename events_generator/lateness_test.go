@@ -0,0 +1,65 @@
+package events_generator
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestLatenessDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		lateness Lateness
+		min      float64
+		max      float64
+	}{
+		{"fixed", Lateness{Distribution: FixedLateness, MinSec: 30}, 30, 30},
+		{"uniform", Lateness{Distribution: UniformLateness, MinSec: 10, MaxSec: 20}, 10, 20},
+		{"exponential capped by max_sec", Lateness{Distribution: ExponentialLateness, MinSec: 5, MeanSec: 100, MaxSec: 50}, 5, 50},
+		{"pareto capped by max_sec", Lateness{Distribution: ParetoLateness, MinSec: 60, Shape: 0.5, MaxSec: 600}, 60, 600},
+	}
+
+	rand.Seed(1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.lateness.validate(); err != nil {
+				t.Fatalf("lateness is invalid: %s", err)
+			}
+
+			capped := false
+			for i := 0; i < 10000; i++ {
+				delay := tt.lateness.delay()
+				if delay < tt.min || delay > tt.max {
+					t.Fatalf("delay %v is out of [%v, %v]", delay, tt.min, tt.max)
+				}
+				capped = capped || delay == tt.lateness.MaxSec
+			}
+			if tt.lateness.MaxSec > 0 && tt.lateness.Distribution != UniformLateness && !capped {
+				t.Errorf("no delay is capped by max_sec %v", tt.lateness.MaxSec)
+			}
+		})
+	}
+}
+
+func TestLatenessValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		lateness Lateness
+		valid    bool
+	}{
+		{"pareto", Lateness{Distribution: ParetoLateness, Ratio: .1, MinSec: 60, Shape: 1.5, MaxSec: 3600}, true},
+		{"pareto without max_sec", Lateness{Distribution: ParetoLateness, Ratio: .1, MinSec: 60, Shape: 1.5}, false},
+		{"pareto without shape", Lateness{Distribution: ParetoLateness, Ratio: .1, MinSec: 60, MaxSec: 3600}, false},
+		{"uniform with max_sec under min_sec", Lateness{Distribution: UniformLateness, MinSec: 20, MaxSec: 10}, false},
+		{"exponential without mean_sec", Lateness{Distribution: ExponentialLateness, MinSec: 20}, false},
+		{"ratios over 1", Lateness{Distribution: FixedLateness, Ratio: .7, FutureRatio: .4}, false},
+		{"unknown distribution", Lateness{Distribution: "normal"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.lateness.validate(); (err == nil) != tt.valid {
+				t.Errorf("validate() returned %v, want valid %t", err, tt.valid)
+			}
+		})
+	}
+}
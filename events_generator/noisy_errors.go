@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"strconv"
 	"strings"
 	"time"
 
@@ -72,14 +73,14 @@ func (nem *noisyErrorMessage) ToJson() ([]byte, error) {
 }
 
 type case2Device struct {
-	OrgId                string
-	DeviceId             int
-	ProbabilityNewError  float64
-	ProbabilityLongError float64
-	LastError            case2Error
-	LastErrorChange      int64
-	IsLongError          bool
-	DebugEvents          bool
+	OrgId                string     `json:"org_id"`
+	DeviceId             int        `json:"device_id"`
+	ProbabilityNewError  float64    `json:"probability_new_error"`
+	ProbabilityLongError float64    `json:"probability_long_error"`
+	LastError            case2Error `json:"last_error"`
+	LastErrorChange      int64      `json:"last_error_change"`
+	IsLongError          bool       `json:"is_long_error"`
+	DebugEvents          bool       `json:"debug_events"`
 }
 
 func generateCase2Devices(orgId string, n int, debugEvents bool) []device {
@@ -108,8 +109,22 @@ func (ctd *case2Device) String() string {
 		ctd.OrgId, ctd.DeviceId, ctd.ProbabilityLongError, ctd.LastError, ctd.LastErrorChange, ctd.DebugEvents)
 }
 
+func (ctd *case2Device) Key() string {
+	return strconv.Itoa(ctd.DeviceId)
+}
+
+func (ctd *case2Device) States() []string {
+	errorState := strings.ToLower(string(ctd.LastError))
+	if ctd.IsLongError {
+		return []string{"long_error", errorState}
+	}
+
+	return []string{"short_error", errorState}
+}
+
 func (ctd *case2Device) Generate() Event {
-	now := time.Now().Unix()
+	generatedAt := time.Now()
+	now := generatedAt.Unix()
 
 	if ctd.IsLongError && (now-ctd.LastErrorChange) <= 7*60 { // keep long error for 7 minutes
 		if ctd.DebugEvents {
@@ -120,6 +135,7 @@ func (ctd *case2Device) Generate() Event {
 			deviceMessage: deviceMessage{
 				DeviceId: ctd.DeviceId,
 				Time:     now,
+				nanos:    int64(generatedAt.Nanosecond()),
 			},
 			ErrorType:    ctd.LastError,
 			ErrorMessage: generateErrorMessage(),
@@ -165,6 +181,7 @@ func (ctd *case2Device) Generate() Event {
 			deviceMessage: deviceMessage{
 				DeviceId: ctd.DeviceId,
 				Time:     now,
+				nanos:    int64(generatedAt.Nanosecond()),
 			},
 			ErrorType:    ctd.LastError,
 			ErrorMessage: generateErrorMessage(),
@@ -3,10 +3,13 @@ package events_generator
 import (
 	"math/rand"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/melan/gen-events/misc"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
 )
 
 var (
@@ -31,20 +34,69 @@ type Event interface {
 	ToJson() ([]byte, error)
 }
 
+// WrappedEvent is implemented by events which decorate another event
+type WrappedEvent interface {
+	Event
+	Unwrap() Event
+}
+
+// FindEvent walks from the event through events it wraps and returns the first one matching, or nil
+func FindEvent(e Event, match func(Event) bool) Event {
+	for {
+		if match(e) {
+			return e
+		}
+
+		wrapped, ok := e.(WrappedEvent)
+		if !ok {
+			return nil
+		}
+		e = wrapped.Unwrap()
+	}
+}
+
+// DeviceKey returns the key of the device which generated the event, regardless of partition keys assigned later
+func DeviceKey(e Event) string {
+	for {
+		wrapped, ok := e.(WrappedEvent)
+		if !ok {
+			return e.PartitionKey()
+		}
+		e = wrapped.Unwrap()
+	}
+}
+
 type device interface {
 	Generate() Event
 	String() string
+	// Key identifies the device within its org
+	Key() string
+	// States returns names of states the device is in, e.g. "long_down" or "broken"
+	States() []string
 }
 
 type deviceMessage struct {
 	DeviceId int   `json:"device_id"`
 	Time     int64 `json:"time"`
+	// nanos is the fraction of the second when the message was generated, Time keeps whole seconds
+	nanos int64
+	// storedAt is when a store-and-forward device buffered the message. It's zero for messages which weren't buffered
+	// and for messages of backlogs restored from checkpoints
+	storedAt time.Time
 }
 
 func (m *deviceMessage) PartitionKey() string {
 	return strconv.Itoa(m.DeviceId)
 }
 
+func (m *deviceMessage) EventTime() time.Time {
+	return time.Unix(m.Time, 0)
+}
+
+func (m *deviceMessage) storedTime() time.Time {
+	return m.storedAt
+}
+
 type OrgSize string
 
 const (
@@ -72,6 +124,28 @@ type Org struct {
 	KinesisPrefix string
 	Devices       []device
 	DebugEvents   bool
+	// Sharing defines if the org publishes events into a stream shared with other orgs
+	Sharing StreamSharing
+	// StreamShards overrides number of shards of the stream if it's positive
+	StreamShards int64
+	// Naming renders the stream name if it's set
+	Naming *StreamNaming
+	// Tagging renders tags of the stream if it's set
+	Tagging *StreamTagging
+	// HistorySize is the number of the latest cycles kept in the history of every device, 0 disables the history
+	HistorySize int
+	// Sequencing numbers events of every device, see Sequence
+	Sequencing bool
+	// Behavior holds optional behaviors of devices, see SetBehavior
+	Behavior *Behavior
+
+	lock              sync.Mutex
+	history           map[int][]DeviceHistoryEntry
+	deviceIndex       map[string]int
+	sequences         []uint64
+	clocks            []DeviceClock
+	cycle             uint64
+	delayedDuplicates []delayedDuplicate
 }
 
 func getNumberOfDevices(orgSize OrgSize) int {
@@ -130,11 +204,46 @@ func GenerateOrg(id string, size OrgSize, caseId Case, debugEvents bool, prefix
 }
 
 func (org *Org) GenerateEvents() []Event {
+	org.lock.Lock()
+	defer org.lock.Unlock()
+
 	events := make([]Event, 0, len(org.Devices))
 
-	for _, d := range org.Devices {
-		if event := d.Generate(); event != nil {
-			events = append(events, event)
+	cycleTime := time.Now()
+	now := cycleTime.Unix()
+	shared := org.Sharing != "" && org.Sharing != NoSharing
+	for i, d := range org.Devices {
+		skew := org.clockSkew(i, now)
+		if c, ok := d.(clocked); ok {
+			c.setClockSkew(skew)
+		}
+		event := d.Generate()
+
+		var deviceEvents []Event
+		if f, ok := d.(forwarding); ok { // buffered events go before the current one
+			deviceEvents = f.forward()
+		}
+		if event != nil {
+			org.applyLateness(event)
+			deviceEvents = append(deviceEvents, event)
+		}
+
+		if org.HistorySize > 0 {
+			org.recordHistory(i, DeviceHistoryEntry{Time: now, States: d.States(), Emitted: len(deviceEvents) > 0})
+		}
+
+		if e, ok := event.(retimable); ok && skew != 0 { // buffered events got the skew when they were stored
+			e.shiftTime(skew)
+		}
+		for _, event := range deviceEvents {
+			events = append(events, org.decorate(d, i, event, cycleTime, 0))
+		}
+	}
+
+	events = org.injectDuplicates(events, cycleTime)
+	if shared {
+		for i, event := range events {
+			events[i] = &tenantEvent{Event: event, orgId: org.OrgId, caseId: org.CaseId}
 		}
 	}
 
@@ -143,11 +252,94 @@ func (org *Org) GenerateEvents() []Event {
 	return events
 }
 
+// decorate wraps the message of the i-th device into events which render its time in the timestamp format, evolve its
+// schema and number it. The message gets the next number of the device unless seq is set
+func (org *Org) decorate(d device, i int, message Event, cycleTime time.Time, seq uint64) Event {
+	evolvedAt := cycleTime
+	if s, ok := message.(stored); ok && !s.storedTime().IsZero() { // buffered messages keep the schema they were stored with
+		evolvedAt = s.storedTime()
+	}
+
+	event := org.formatTime(d, message)
+	event = org.evolve(d, event, evolvedAt)
+	if org.Sequencing && seq > 0 {
+		event = &sequencedEvent{Event: event, seq: seq}
+	} else if org.Sequencing {
+		event = org.sequence(i, event)
+	}
+
+	return event
+}
+
 func (org *Org) StreamName() string {
-	return org.GlobalPrefix + "_" + org.KinesisPrefix + "_" + org.OrgId
+	name, err := org.RenderStreamName()
+	if err != nil {
+		log.WithError(err).Panic("can't get stream name")
+	}
+
+	return name
+}
+
+// RenderStreamName returns the name of the stream, topic or file where events of the org are published
+func (org *Org) RenderStreamName() (string, error) {
+	if org.Naming == nil {
+		return org.defaultStreamName(), nil
+	}
+
+	return org.Naming.Name(org.streamNameData())
+}
+
+// StreamTags returns tags of the stream where events of the org are published
+func (org *Org) StreamTags() (map[string]string, error) {
+	if org.Tagging == nil {
+		return nil, nil
+	}
+
+	return org.Tagging.Tags(org.streamNameData())
+}
+
+func (org *Org) streamNameData() StreamNameData {
+	data := StreamNameData{
+		Prefix:  org.GlobalPrefix,
+		Case:    org.KinesisPrefix,
+		OrgId:   org.OrgId,
+		OrgSize: string(org.OrgSize),
+	}
+	switch org.Sharing {
+	case CaseSharing:
+		data.OrgId = sharedStreamSuffix
+		data.OrgSize = ""
+		data.Shared = true
+	case AllSharing:
+		data.Case = "all"
+		data.OrgId = sharedStreamSuffix
+		data.OrgSize = ""
+		data.Shared = true
+	}
+
+	return data
+}
+
+func (org *Org) defaultStreamName() string {
+	switch org.Sharing {
+	case CaseSharing:
+		return org.GlobalPrefix + "_" + org.KinesisPrefix + "_" + sharedStreamSuffix
+	case AllSharing:
+		return org.GlobalPrefix + "_" + sharedStreamSuffix
+	default:
+		return org.GlobalPrefix + "_" + org.KinesisPrefix + "_" + org.OrgId
+	}
 }
 
 func (org *Org) NumberOfStreamShards() int64 {
+	if org.StreamShards > 0 {
+		return org.StreamShards
+	}
+
+	return org.orgSizeShards()
+}
+
+func (org *Org) orgSizeShards() int64 {
 	switch org.OrgSize {
 	case TinyOrg:
 		return 1
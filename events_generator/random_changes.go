@@ -42,6 +42,8 @@ type randomChangeMessage struct {
 	LastName       string  `json:"last_name"`
 	ChangeDate     int64   `json:"change_date"`
 	CustomerRating float32 `json:"customer_rating"`
+	// nanos is the fraction of the second of the change, ChangeDate keeps whole seconds
+	nanos int64
 }
 
 func (m *randomChangeMessage) ToJson() ([]byte, error) {
@@ -52,6 +54,10 @@ func (m *randomChangeMessage) PartitionKey() string {
 	return m.Id
 }
 
+func (m *randomChangeMessage) EventTime() time.Time {
+	return time.Unix(m.ChangeDate, 0)
+}
+
 type case5 struct {
 	OrgId          string  `json:"org_id"`
 	Id             string  `json:"id"`
@@ -60,6 +66,8 @@ type case5 struct {
 	LastChangeData int64   `json:"last_change_data"`
 	CurrentRating  float64 `json:"current_rating"`
 	DebugEvents    bool    `json:"debug_events"`
+
+	behavior *Behavior
 }
 
 func generateCase5(orgId string, n int, debugEvents bool) []device {
@@ -92,13 +100,27 @@ func (c *case5) String() string {
 		c.OrgId, c.Id, c.FirstName, c.LastName, c.CurrentRating)
 }
 
+func (c *case5) setBehavior(b *Behavior) {
+	c.behavior = b
+}
+
+func (c *case5) Key() string {
+	return c.Id
+}
+
+func (c *case5) States() []string {
+	return nil
+}
+
 func (c *case5) Generate() Event {
-	now := time.Now().Unix()
+	generatedAt := time.Now()
+	now := generatedAt.Unix()
 
 	// 3.6% of contacts should send messages
 	if rand.Float32() < .036 {
 		newRating := c.CurrentRating + rand.NormFloat64()
-		if rand.Float32() < .77 { // 77% of the sent messages are from the present
+		// 77% of the sent messages are from the present unless the lateness of the case is set
+		if rand.Float32() < .77 || lateness(c.behavior, CaseFive) != nil {
 			// send message from present
 			if c.DebugEvents {
 				log.Printf("%d: c %s/%s sends update from present", now, c.OrgId, c.Id)
@@ -121,6 +143,7 @@ func (c *case5) Generate() Event {
 			LastName:       c.LastName,
 			CustomerRating: float32(newRating),
 			ChangeDate:     now,
+			nanos:          int64(generatedAt.Nanosecond()),
 		}
 	} else {
 		if c.DebugEvents {
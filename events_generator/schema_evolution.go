@@ -0,0 +1,306 @@
+package events_generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/melan/gen-events/misc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var evolvedEvents = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: misc.MetricsPrefix,
+		Name:      "evolved_events",
+		Help:      "Number of events published with the evolved schema version",
+	},
+	[]string{"orgId", "caseId", "version"})
+
+type FieldOp string
+
+const (
+	AddField        FieldOp = "add"
+	RenameField     FieldOp = "rename"
+	ChangeFieldType FieldOp = "change_type"
+	RemoveField     FieldOp = "remove"
+)
+
+// FieldChange changes a top level field of events. Add sets Value, rename moves the field To another name,
+// change_type converts the value to Type: int, float, string or bool
+type FieldChange struct {
+	Op    FieldOp         `json:"op"`
+	Field string          `json:"field"`
+	To    string          `json:"to,omitempty"`
+	Type  string          `json:"type,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// SchemaStep is a version of the schema of a case. Devices switch to it between AtSec and AtSec+RolloutSec after
+// the start of the run, each one at its own moment like with a firmware rollout
+type SchemaStep struct {
+	AtSec      float64       `json:"at_sec"`
+	RolloutSec float64       `json:"rollout_sec"`
+	Changes    []FieldChange `json:"changes"`
+}
+
+// SchemaEvolution changes schemas of events of cases over the course of the run. The original schema is version 1,
+// the n-th step of a case makes version n+1 and includes changes of the previous steps
+type SchemaEvolution struct {
+	Start time.Time
+	Steps map[Case][]SchemaStep
+
+	// changes are changes of every version of cases, starting from version 2
+	changes map[Case][][]FieldChange
+}
+
+// LoadSchemaEvolution reads steps of cases from a JSON file like
+// {"temperature_reading": [{"at_sec": 300, "rollout_sec": 600, "changes": [{"op": "change_type", "field": "temp", "type": "float"}]}]}
+func LoadSchemaEvolution(fileName string, start time.Time) (*SchemaEvolution, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("can't read schema evolution %s: %s", fileName, err)
+	}
+
+	var steps map[Case][]SchemaStep
+	if err := json.Unmarshal(content, &steps); err != nil {
+		return nil, fmt.Errorf("can't parse schema evolution %s: %s", fileName, err)
+	}
+	changes := make(map[Case][][]FieldChange, len(steps))
+	for caseId, caseSteps := range steps {
+		switch caseId {
+		case CaseOne, CaseTwo, CaseThree, CaseFour, CaseFive:
+		default:
+			return nil, fmt.Errorf("unknown case %q in schema evolution %s", caseId, fileName)
+		}
+		var versionChanges []FieldChange
+		for i, step := range caseSteps {
+			if err := step.validate(); err != nil {
+				return nil, fmt.Errorf("invalid step %d of %s in %s: %s", i+1, caseId, fileName, err)
+			}
+			versionChanges = append(versionChanges[:len(versionChanges):len(versionChanges)], step.Changes...)
+			changes[caseId] = append(changes[caseId], versionChanges)
+		}
+	}
+
+	return &SchemaEvolution{Start: start, Steps: steps, changes: changes}, nil
+}
+
+func (s SchemaStep) validate() error {
+	if s.AtSec < 0 || s.RolloutSec < 0 {
+		return fmt.Errorf("at_sec and rollout_sec have to be positive")
+	}
+
+	for _, change := range s.Changes {
+		if change.Field == "" {
+			return fmt.Errorf("field of %s has to be set", change.Op)
+		}
+
+		switch change.Op {
+		case AddField:
+			if len(change.Value) == 0 || !json.Valid(change.Value) {
+				return fmt.Errorf("add of %s needs a JSON value", change.Field)
+			}
+		case RenameField:
+			if change.To == "" {
+				return fmt.Errorf("rename of %s needs the new name in to", change.Field)
+			}
+		case ChangeFieldType:
+			switch change.Type {
+			case "int", "float", "string", "bool":
+			default:
+				return fmt.Errorf("unknown type %q of %s", change.Type, change.Field)
+			}
+		case RemoveField:
+		default:
+			return fmt.Errorf("unknown op %q", change.Op)
+		}
+	}
+
+	return nil
+}
+
+// version returns the schema version of the device at the moment
+func (e *SchemaEvolution) version(caseId Case, orgId string, deviceKey string, now time.Time) int {
+	steps := e.Steps[caseId]
+	if len(steps) == 0 {
+		return 1
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(orgId + "/" + deviceKey))
+	rollout := float64(h.Sum32()) / math.MaxUint32 // where the device is in every rollout
+
+	elapsed := now.Sub(e.Start).Seconds()
+	version := 1
+	for i, step := range steps {
+		if elapsed < step.AtSec+rollout*step.RolloutSec {
+			break
+		}
+		version = i + 2
+	}
+
+	return version
+}
+
+// VersionedEvent knows the version of the schema of its data
+type VersionedEvent interface {
+	Event
+	SchemaVersion() int
+}
+
+type evolvedEvent struct {
+	Event
+	version int
+	changes []FieldChange
+}
+
+func (e *evolvedEvent) Unwrap() Event {
+	return e.Event
+}
+
+func (e *evolvedEvent) SchemaVersion() int {
+	return e.version
+}
+
+func (e *evolvedEvent) ToJson() ([]byte, error) {
+	js, err := e.Event.ToJson()
+	if err != nil || len(e.changes) == 0 {
+		return js, err
+	}
+
+	// fields are changed where they are, so they keep the order of the message. Added fields go last
+	fields, err := splitJsonObject(js)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, change := range e.changes {
+		i := fieldIndex(fields, change.Field)
+		switch change.Op {
+		case AddField:
+			if i < 0 {
+				value, err := json.Marshal(change.Value)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, jsonField{name: change.Field, value: value})
+			}
+		case RenameField:
+			if j := fieldIndex(fields, change.To); i >= 0 && j >= 0 && j != i { // the renamed field replaces the existing one
+				fields[j].value = fields[i].value
+				fields = append(fields[:i], fields[i+1:]...)
+			} else if i >= 0 {
+				fields[i].name = change.To
+			}
+		case ChangeFieldType:
+			if i >= 0 {
+				decoder := json.NewDecoder(bytes.NewReader(fields[i].value))
+				decoder.UseNumber()
+				var value interface{}
+				if err := decoder.Decode(&value); err != nil {
+					return nil, err
+				}
+				if fields[i].value, err = json.Marshal(convertField(value, change.Type)); err != nil {
+					return nil, err
+				}
+			}
+		case RemoveField:
+			if i >= 0 {
+				fields = append(fields[:i], fields[i+1:]...)
+			}
+		}
+	}
+
+	return joinJsonObject(fields)
+}
+
+// convertField converts a value decoded with json.Number to the type
+func convertField(value interface{}, fieldType string) interface{} {
+	var text string
+	switch v := value.(type) {
+	case json.Number:
+		text = v.String()
+	case string:
+		text = v
+	case bool:
+		text = strconv.FormatBool(v)
+	default:
+		return value
+	}
+
+	switch fieldType {
+	case "string":
+		return text
+	case "bool":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			b, _ := strconv.ParseBool(text)
+			return b
+		}
+		return f != 0
+	case "int", "float":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			if b, err := strconv.ParseBool(text); err == nil && b {
+				f = 1
+			}
+		}
+		if fieldType == "int" {
+			return json.Number(strconv.FormatInt(int64(math.Round(f)), 10))
+		}
+		number := strconv.FormatFloat(f, 'f', -1, 64)
+		if !strings.ContainsAny(number, ".eE") { // keep it a float for parsers which tell them apart
+			number += ".0"
+		}
+		return json.Number(number)
+	default:
+		return value
+	}
+}
+
+// SchemaVersion returns the version of the schema of the data of the event if the schema of its case evolves.
+// 1 is the original schema
+func SchemaVersion(e Event) (int, bool) {
+	found := FindEvent(e, func(e Event) bool {
+		_, ok := e.(VersionedEvent)
+		return ok
+	})
+	if found == nil {
+		return 0, false
+	}
+
+	return found.(VersionedEvent).SchemaVersion(), true
+}
+
+func schemaEvolution(b *Behavior) *SchemaEvolution {
+	if b == nil {
+		return nil
+	}
+
+	return b.SchemaEvolution
+}
+
+// evolve changes the schema of the event of the device to the version the device has at the moment
+func (org *Org) evolve(d device, e Event, now time.Time) Event {
+	evolution := schemaEvolution(org.Behavior)
+	if evolution == nil || len(evolution.Steps[org.CaseId]) == 0 {
+		return e
+	}
+
+	version := evolution.version(org.CaseId, org.OrgId, d.Key(), now)
+	var changes []FieldChange
+	if version > 1 {
+		changes = evolution.changes[org.CaseId][version-2]
+		evolvedEvents.WithLabelValues(org.OrgId, string(org.CaseId), strconv.Itoa(version)).Inc()
+	}
+
+	return &evolvedEvent{Event: e, version: version, changes: changes}
+}
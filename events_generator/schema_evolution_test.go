@@ -0,0 +1,114 @@
+package events_generator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConvertField(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     interface{}
+		fieldType string
+		want      interface{}
+	}{
+		{"int to string", json.Number("42"), "string", "42"},
+		{"int to float", json.Number("42"), "float", json.Number("42.0")},
+		{"float to int", json.Number("41.6"), "int", json.Number("42")},
+		{"float stays float", json.Number("1.5"), "float", json.Number("1.5")},
+		{"zero to bool", json.Number("0"), "bool", false},
+		{"number to bool", json.Number("3"), "bool", true},
+		{"numeric string to int", "17", "int", json.Number("17")},
+		{"text to int", "UP", "int", json.Number("0")},
+		{"text to bool", "UP", "bool", false},
+		{"true to int", true, "int", json.Number("1")},
+		{"false to string", false, "string", "false"},
+		{"bool string to bool", "true", "bool", true},
+		{"object is kept", map[string]interface{}{"a": "b"}, "string", map[string]interface{}{"a": "b"}},
+		{"null is kept", nil, "int", nil},
+		{"unknown type", json.Number("42"), "date", json.Number("42")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertField(tt.value, tt.fieldType); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convertField(%#v, %s) = %#v, want %#v", tt.value, tt.fieldType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvolvedEventToJson(t *testing.T) {
+	message := &heartbeatMessage{deviceMessage: deviceMessage{DeviceId: 7, Time: 1540000000}, Status: StatusUp}
+
+	tests := []struct {
+		name    string
+		changes []FieldChange
+		want    string
+	}{
+		{"no changes", nil, `{"device_id":7,"time":1540000000,"status":"UP"}`},
+		{"add goes last", []FieldChange{{Op: AddField, Field: "fw", Value: json.RawMessage(`{"v":2}`)}},
+			`{"device_id":7,"time":1540000000,"status":"UP","fw":{"v":2}}`},
+		{"add of an existing field", []FieldChange{{Op: AddField, Field: "status", Value: json.RawMessage(`1`)}},
+			`{"device_id":7,"time":1540000000,"status":"UP"}`},
+		{"rename keeps the place", []FieldChange{{Op: RenameField, Field: "device_id", To: "id"}},
+			`{"id":7,"time":1540000000,"status":"UP"}`},
+		{"rename over an existing field", []FieldChange{{Op: RenameField, Field: "status", To: "time"}},
+			`{"device_id":7,"time":"UP"}`},
+		{"change type keeps the place", []FieldChange{{Op: ChangeFieldType, Field: "device_id", Type: "string"}},
+			`{"device_id":"7","time":1540000000,"status":"UP"}`},
+		{"remove", []FieldChange{{Op: RemoveField, Field: "time"}}, `{"device_id":7,"status":"UP"}`},
+		{"changes of versions in order", []FieldChange{
+			{Op: AddField, Field: "fw", Value: json.RawMessage(`1`)},
+			{Op: ChangeFieldType, Field: "fw", Type: "string"},
+			{Op: RenameField, Field: "fw", To: "firmware"},
+			{Op: RemoveField, Field: "device_id"},
+		}, `{"time":1540000000,"status":"UP","firmware":"1"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js, err := (&evolvedEvent{Event: message, version: 2, changes: tt.changes}).ToJson()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(js) != tt.want {
+				t.Errorf("got %s, want %s", js, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecorateEvolvesBufferedMessages(t *testing.T) {
+	start := time.Unix(1540000000, 0)
+	changes := []FieldChange{{Op: AddField, Field: "fw", Value: json.RawMessage(`2`)}}
+	org := &Org{OrgId: "1", CaseId: CaseOne, Behavior: &Behavior{SchemaEvolution: &SchemaEvolution{
+		Start:   start,
+		Steps:   map[Case][]SchemaStep{CaseOne: {{AtSec: 60, Changes: changes}}},
+		changes: map[Case][][]FieldChange{CaseOne: {changes}},
+	}}}
+	d := &case1Device{OrgId: "1"}
+	cycleTime := start.Add(2 * time.Minute)
+
+	tests := []struct {
+		name     string
+		storedAt time.Time
+		want     int
+	}{
+		{"fresh message gets the version of the cycle", time.Time{}, 2},
+		{"message buffered before the step keeps the original version", start.Add(10 * time.Second), 1},
+		{"message buffered after the step", start.Add(90 * time.Second), 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &heartbeatMessage{deviceMessage: deviceMessage{Time: start.Unix(), storedAt: tt.storedAt}, Status: StatusUp}
+			version, ok := SchemaVersion(org.decorate(d, 0, message, cycleTime, 0))
+			if !ok || version != tt.want {
+				t.Errorf("got version %d, want %d", version, tt.want)
+			}
+		})
+	}
+}
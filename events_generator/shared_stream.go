@@ -0,0 +1,95 @@
+package events_generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+type StreamSharing string
+
+const (
+	NoSharing   StreamSharing = "none"
+	CaseSharing StreamSharing = "case"
+	AllSharing  StreamSharing = "all"
+)
+
+const sharedStreamSuffix = "shared"
+
+// TenantEvent is implemented by events which carry the org and the case they were generated for.
+// Events are tagged this way when many orgs publish into the same stream
+type TenantEvent interface {
+	Event
+	Tenant() (orgId string, caseId Case)
+}
+
+// Tenant returns the org and the case of the event if they are known from the event itself
+func Tenant(e Event) (string, Case, bool) {
+	found := FindEvent(e, func(e Event) bool {
+		_, ok := e.(TenantEvent)
+		return ok
+	})
+	if found == nil {
+		return "", "", false
+	}
+
+	orgId, caseId := found.(TenantEvent).Tenant()
+	return orgId, caseId, true
+}
+
+type tenantEvent struct {
+	Event
+	orgId  string
+	caseId Case
+}
+
+func (e *tenantEvent) Unwrap() Event {
+	return e.Event
+}
+
+func (e *tenantEvent) Tenant() (string, Case) {
+	return e.orgId, e.caseId
+}
+
+func (e *tenantEvent) ToJson() ([]byte, error) {
+	js, err := e.Event.ToJson()
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := json.Marshal(struct {
+		OrgId string `json:"org_id"`
+		Case  Case   `json:"case"`
+	}{e.orgId, e.caseId})
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeJsonObjects(tenant, js)
+}
+
+// mergeJsonObjects appends fields of the second serialized json object to fields of the first one
+func mergeJsonObjects(first, second []byte) ([]byte, error) {
+	first = bytes.TrimSpace(first)
+	second = bytes.TrimSpace(second)
+	if len(first) < 2 || first[0] != '{' || len(second) < 2 || second[0] != '{' {
+		return nil, fmt.Errorf("can't merge %s and %s: both have to be json objects", first, second)
+	}
+
+	secondFields := bytes.TrimSpace(second[1 : len(second)-1])
+	if len(secondFields) == 0 {
+		return first, nil
+	}
+
+	firstFields := bytes.TrimSpace(first[1 : len(first)-1])
+	merged := make([]byte, 0, len(first)+len(second))
+	merged = append(merged, '{')
+	if len(firstFields) > 0 {
+		merged = append(merged, firstFields...)
+		merged = append(merged, ',')
+	}
+	merged = append(merged, secondFields...)
+	merged = append(merged, '}')
+
+	return merged, nil
+}
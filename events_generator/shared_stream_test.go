@@ -0,0 +1,34 @@
+package events_generator
+
+import "testing"
+
+func TestMergeJsonObjects(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   string
+		second  string
+		want    string
+		wantErr bool
+	}{
+		{"fields of both", `{"org_id":"1"}`, `{"device_id":7,"time":1}`, `{"org_id":"1","device_id":7,"time":1}`, false},
+		{"empty second", `{"org_id":"1"}`, `{}`, `{"org_id":"1"}`, false},
+		{"empty first", `{}`, `{"device_id":7}`, `{"device_id":7}`, false},
+		{"whitespace around", " {\"org_id\":\"1\"}\n", "\n{ \"device_id\":7 }\n", `{"org_id":"1","device_id":7}`, false},
+		{"nested objects", `{"a":{"b":1}}`, `{"c":{"d":[1,2]}}`, `{"a":{"b":1},"c":{"d":[1,2]}}`, false},
+		{"second is an array", `{"org_id":"1"}`, `[1,2]`, "", true},
+		{"first is a string", `"org"`, `{"device_id":7}`, "", true},
+		{"second is empty", `{"org_id":"1"}`, ``, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeJsonObjects([]byte(tt.first), []byte(tt.second))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mergeJsonObjects() returned error %v, want error %t", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package events_generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+const (
+	StatusUp         = "UP"
+	StatusDegraded   = "DEGRADED"
+	StatusDown       = "DOWN"
+	StatusRecovering = "RECOVERING"
+)
+
+var AllStatuses = []string{StatusUp, StatusDegraded, StatusDown, StatusRecovering}
+
+// StatusModel is a Markov chain of heartbeat statuses. Transitions[from][to] is the probability to change the status
+// in a cycle, the status stays the same with the remaining probability
+type StatusModel struct {
+	Transitions map[string]map[string]float64 `json:"transitions"`
+}
+
+func DefaultStatusModel() *StatusModel {
+	return &StatusModel{
+		Transitions: map[string]map[string]float64{
+			StatusUp:         {StatusDegraded: .05, StatusDown: .02},
+			StatusDegraded:   {StatusUp: .2, StatusDown: .1},
+			StatusDown:       {StatusRecovering: .3},
+			StatusRecovering: {StatusUp: .5, StatusDown: .1},
+		},
+	}
+}
+
+// LoadStatusModel reads a model from a JSON file like {"transitions": {"UP": {"DOWN": 0.05}, "DOWN": {"UP": 0.3}}}
+func LoadStatusModel(fileName string) (*StatusModel, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("can't read status model %s: %s", fileName, err)
+	}
+
+	var model StatusModel
+	if err := json.Unmarshal(content, &model); err != nil {
+		return nil, fmt.Errorf("can't parse status model %s: %s", fileName, err)
+	}
+	if err := model.validate(); err != nil {
+		return nil, fmt.Errorf("invalid status model %s: %s", fileName, err)
+	}
+
+	return &model, nil
+}
+
+func (m *StatusModel) validate() error {
+	for from, transitions := range m.Transitions {
+		if !isStatus(from) {
+			return fmt.Errorf("unknown status %q", from)
+		}
+
+		var total float64
+		for to, p := range transitions {
+			if !isStatus(to) {
+				return fmt.Errorf("unknown status %q", to)
+			}
+			if p < 0 || math.IsNaN(p) {
+				return fmt.Errorf("probability of %s -> %s has to be positive, got %v", from, to, p)
+			}
+			total += p
+		}
+		if total > 1 {
+			return fmt.Errorf("probabilities of transitions from %s add up to %v, more than 1", from, total)
+		}
+	}
+
+	return nil
+}
+
+// Next picks the status following the status by chance from [0, 1)
+func (m *StatusModel) Next(status string, chance float64) string {
+	transitions := m.Transitions[status]
+	targets := make([]string, 0, len(transitions))
+	for to := range transitions {
+		targets = append(targets, to)
+	}
+	sort.Strings(targets) // keeps the pick stable for the same chance
+
+	for _, to := range targets {
+		chance -= transitions[to]
+		if chance < 0 {
+			return to
+		}
+	}
+
+	return status
+}
+
+func isStatus(status string) bool {
+	for _, s := range AllStatuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
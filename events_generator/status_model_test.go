@@ -0,0 +1,59 @@
+package events_generator
+
+import "testing"
+
+func TestStatusModelNext(t *testing.T) {
+	model := DefaultStatusModel()
+
+	// transitions from a status are picked in the alphabetical order of their targets
+	tests := []struct {
+		status string
+		chance float64
+		want   string
+	}{
+		{StatusUp, 0, StatusDegraded},
+		{StatusUp, .049, StatusDegraded},
+		{StatusUp, .05, StatusDown},
+		{StatusUp, .069, StatusDown},
+		{StatusUp, .08, StatusUp},
+		{StatusUp, .999, StatusUp},
+		{StatusDegraded, .05, StatusDown},
+		{StatusDegraded, .15, StatusUp},
+		{StatusDegraded, .35, StatusDegraded},
+		{StatusDown, .29, StatusRecovering},
+		{StatusDown, .5, StatusDown},
+		{StatusRecovering, .05, StatusDown},
+		{StatusRecovering, .1, StatusUp},
+		{StatusRecovering, .7, StatusRecovering},
+		{"UNKNOWN", 0, "UNKNOWN"},
+	}
+
+	for _, tt := range tests {
+		if got := model.Next(tt.status, tt.chance); got != tt.want {
+			t.Errorf("Next(%s, %v) = %s, want %s", tt.status, tt.chance, got, tt.want)
+		}
+	}
+}
+
+func TestStatusModelValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		transitions map[string]map[string]float64
+		valid       bool
+	}{
+		{"default", DefaultStatusModel().Transitions, true},
+		{"unknown source status", map[string]map[string]float64{"SLEEPING": {StatusUp: .1}}, false},
+		{"unknown target status", map[string]map[string]float64{StatusUp: {"SLEEPING": .1}}, false},
+		{"negative probability", map[string]map[string]float64{StatusUp: {StatusDown: -.1}}, false},
+		{"probabilities over 1", map[string]map[string]float64{StatusUp: {StatusDown: .6, StatusDegraded: .5}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &StatusModel{Transitions: tt.transitions}
+			if err := model.validate(); (err == nil) != tt.valid {
+				t.Errorf("validate() returned %v, want valid %t", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package events_generator
+
+import (
+	"time"
+
+	"github.com/melan/gen-events/misc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	bufferedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: misc.MetricsPrefix,
+			Name:      "buffered_events",
+			Help:      "Number of events buffered by devices while they were down",
+		},
+		[]string{"orgId", "caseId"})
+
+	forwardedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: misc.MetricsPrefix,
+			Name:      "forwarded_events",
+			Help:      "Number of buffered events sent by devices when they were back",
+		},
+		[]string{"orgId", "caseId"})
+
+	droppedBufferedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: misc.MetricsPrefix,
+			Name:      "dropped_buffered_events",
+			Help:      "Number of the oldest buffered events dropped because buffers of devices were full",
+		},
+		[]string{"orgId", "caseId"})
+)
+
+// StoreAndForward makes devices buffer events while they are down and send them in a burst with their original
+// times when they are back
+type StoreAndForward struct {
+	// MaxEvents limits the buffer of a device, the oldest events are dropped when it's full. 0 means no limit
+	MaxEvents int
+}
+
+// forwarding is implemented by devices which buffer events while they are down. forward returns buffered events
+// once the device is back and empties the buffer
+type forwarding interface {
+	forward() []Event
+}
+
+// stored is implemented by messages which know when they were buffered
+type stored interface {
+	storedTime() time.Time
+}
+
+// overflow returns how many of the oldest events of the buffer of the size have to be dropped
+func (s *StoreAndForward) overflow(size int) int {
+	if s.MaxEvents <= 0 || size <= s.MaxEvents {
+		return 0
+	}
+
+	return size - s.MaxEvents
+}
+
+func storeAndForward(b *Behavior) *StoreAndForward {
+	if b == nil {
+		return nil
+	}
+
+	return b.StoreAndForward
+}
@@ -0,0 +1,63 @@
+package events_generator
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// StreamNameData is available to stream name templates
+type StreamNameData struct {
+	Prefix  string
+	Case    string
+	OrgId   string
+	OrgSize string
+	// Shared is true if the stream is shared by many orgs. OrgId is "shared" for such streams and Case is "all"
+	// if the stream is shared by all cases
+	Shared bool
+	Tags   map[string]string
+}
+
+// StreamNaming renders names of streams, topics and files with a Go template
+type StreamNaming struct {
+	template *template.Template
+	tags     map[string]string
+}
+
+var streamNamingFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"trunc": func(n int, s string) string {
+		if len(s) > n {
+			return s[:n]
+		}
+		return s
+	},
+}
+
+func NewStreamNaming(text string, tags map[string]string) (*StreamNaming, error) {
+	t, err := template.New("stream_name").Funcs(streamNamingFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse stream name template %q: %s", text, err)
+	}
+
+	return &StreamNaming{
+		template: t,
+		tags:     tags,
+	}, nil
+}
+
+func (n *StreamNaming) Name(data StreamNameData) (string, error) {
+	if data.Tags == nil {
+		data.Tags = n.tags
+	}
+
+	var name bytes.Buffer
+	if err := n.template.Execute(&name, data); err != nil {
+		return "", fmt.Errorf("can't render stream name for org %s of case %s: %s", data.OrgId, data.Case, err)
+	}
+
+	return strings.TrimSpace(name.String()), nil
+}
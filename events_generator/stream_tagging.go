@@ -0,0 +1,75 @@
+package events_generator
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+const (
+	CaseTag      = "gen-events:case"
+	OrgIdTag     = "gen-events:org-id"
+	OrgSizeTag   = "gen-events:org-size"
+	VersionTag   = "gen-events:version"
+	RunIdTag     = "gen-events:run-id"
+	OwnerTag     = "gen-events:owner"
+	ExpiresAtTag = "gen-events:expires-at"
+)
+
+// StreamTagging renders tags of a stream. Tag values are Go templates with the same data as stream name templates.
+// Automatic tags describe the org and the run which created the stream
+type StreamTagging struct {
+	tags      map[string]string
+	templates map[string]*template.Template
+	runTags   map[string]string
+	autoTags  bool
+}
+
+// NewStreamTagging creates tagging with templated tags. runTags (version, run id, owner, expiration)
+// and tags derived from the org are added only if autoTags is set
+func NewStreamTagging(tags map[string]string, autoTags bool, runTags map[string]string) (*StreamTagging, error) {
+	templates := make(map[string]*template.Template, len(tags))
+	for k, v := range tags {
+		t, err := template.New(k).Funcs(streamNamingFuncs).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse template %q of tag %s: %s", v, k, err)
+		}
+		templates[k] = t
+	}
+
+	return &StreamTagging{
+		tags:      tags,
+		templates: templates,
+		runTags:   runTags,
+		autoTags:  autoTags,
+	}, nil
+}
+
+func (t *StreamTagging) Tags(data StreamNameData) (map[string]string, error) {
+	if data.Tags == nil {
+		data.Tags = t.tags
+	}
+
+	tags := make(map[string]string, len(t.templates)+len(t.runTags)+3)
+	if t.autoTags {
+		tags[CaseTag] = data.Case
+		tags[OrgIdTag] = data.OrgId
+		if data.OrgSize != "" {
+			tags[OrgSizeTag] = data.OrgSize
+		}
+
+		for k, v := range t.runTags {
+			tags[k] = v
+		}
+	}
+
+	for k, tmpl := range t.templates {
+		var value bytes.Buffer
+		if err := tmpl.Execute(&value, data); err != nil {
+			return nil, fmt.Errorf("can't render tag %s for org %s of case %s: %s", k, data.OrgId, data.Case, err)
+		}
+		tags[k] = value.String()
+	}
+
+	return tags, nil
+}
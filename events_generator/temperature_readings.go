@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -75,6 +76,8 @@ type case34Device struct {
 	StepsLeftLongSpike int    `json:"steps_left_long_spike"`
 	DebugEvents        bool   `json:"debug_events"`
 	Case               Case   `json:"case"`
+
+	behavior *Behavior
 }
 
 func generateCase34Devices(caseName Case, orgId string, n int, debugEvents bool) []*case34Device {
@@ -123,9 +126,26 @@ func (d *case34Device) String() string {
 		d.OrgId, d.DeviceId, d.DeviceName, float64(d.SumTemperature)/float64(d.CountMeasurements), d.LastTemperature, d.DebugEvents)
 }
 
+func (d *case34Device) setBehavior(b *Behavior) {
+	d.behavior = b
+}
+
+func (d *case34Device) Key() string {
+	return strconv.Itoa(d.DeviceId)
+}
+
+func (d *case34Device) States() []string {
+	if d.IsInLongSpike {
+		return []string{"long_spike"}
+	}
+
+	return []string{"normal"}
+}
+
 func (d *case34Device) Generate() Event {
 	mean := float64(d.SumTemperature) / float64(d.CountMeasurements)
-	now := time.Now().Unix()
+	generatedAt := time.Now()
+	now := generatedAt.Unix()
 
 	if d.IsInLongSpike && d.StepsLeftLongSpike > 0 {
 		// proceed with long spike
@@ -192,7 +212,7 @@ func (d *case34Device) Generate() Event {
 		case34NormalLevelDevice.WithLabelValues(d.OrgId, string(d.Case)).Inc()
 	}
 
-	if rand.Float32() < .01 { // send late message
+	if rand.Float32() < .01 && lateness(d.behavior, d.Case) == nil { // send late message
 		now = now - (10+rand.Int63n(10))*60
 		if d.DebugEvents {
 			log.Printf("%d: d %s/%d late message", now, d.OrgId, d.DeviceId)
@@ -207,6 +227,7 @@ func (d *case34Device) Generate() Event {
 		deviceMessage: deviceMessage{
 			DeviceId: d.DeviceId,
 			Time:     now,
+			nanos:    int64(generatedAt.Nanosecond()),
 		},
 		DeviceName:  d.DeviceName,
 		Temperature: d.LastTemperature,
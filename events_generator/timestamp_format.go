@@ -0,0 +1,151 @@
+package events_generator
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"time"
+)
+
+type TimestampFormat string
+
+const (
+	UnixSeconds      TimestampFormat = "unix"
+	UnixMilliseconds TimestampFormat = "unix_ms"
+	UnixMicroseconds TimestampFormat = "unix_us"
+	UnixNanoseconds  TimestampFormat = "unix_ns"
+	// RFC3339Timestamp is a string with milliseconds and the zone offset of the device
+	RFC3339Timestamp TimestampFormat = "rfc3339"
+	// LocalTimestamp is a string with the local time of the device without the zone
+	LocalTimestamp TimestampFormat = "local"
+)
+
+var AllTimestampFormats = []TimestampFormat{UnixSeconds, UnixMilliseconds, UnixMicroseconds, UnixNanoseconds,
+	RFC3339Timestamp, LocalTimestamp}
+
+const (
+	RFC3339TimestampLayout = "2006-01-02T15:04:05.000Z07:00"
+	LocalTimestampLayout   = "2006-01-02 15:04:05"
+)
+
+// precision is the smallest unit of time which the format keeps
+func (f TimestampFormat) precision() time.Duration {
+	switch f {
+	case UnixMilliseconds, RFC3339Timestamp:
+		return time.Millisecond
+	case UnixMicroseconds:
+		return time.Microsecond
+	case UnixNanoseconds:
+		return time.Nanosecond
+	default:
+		return time.Second
+	}
+}
+
+// timestamped is implemented by messages which carry the time of the event in a field in unix seconds.
+// The time has the fraction of the second which the field doesn't keep
+type timestamped interface {
+	timeField() (string, time.Time)
+}
+
+func (m *deviceMessage) timeField() (string, time.Time) {
+	return "time", time.Unix(m.Time, m.nanos)
+}
+
+func (m *randomChangeMessage) timeField() (string, time.Time) {
+	return "change_date", time.Unix(m.ChangeDate, m.nanos)
+}
+
+type formattedTimeEvent struct {
+	Event
+	format TimestampFormat
+	zone   *time.Location
+}
+
+func (e *formattedTimeEvent) Unwrap() Event {
+	return e.Event
+}
+
+// EventTime has the fraction of the second which the formatted time field has
+func (e *formattedTimeEvent) EventTime() time.Time {
+	message, ok := e.Event.(timestamped)
+	if !ok {
+		t, _ := EventTime(e.Event)
+		return t
+	}
+	_, t := message.timeField()
+
+	return t.Truncate(e.format.precision())
+}
+
+func (e *formattedTimeEvent) ToJson() ([]byte, error) {
+	js, err := e.Event.ToJson()
+	if err != nil {
+		return nil, err
+	}
+
+	message, ok := e.Event.(timestamped)
+	if !ok {
+		return js, nil
+	}
+	field, t := message.timeField()
+
+	var value interface{}
+	switch e.format {
+	case UnixMilliseconds:
+		value = t.UnixNano() / int64(time.Millisecond)
+	case UnixMicroseconds:
+		value = t.UnixNano() / int64(time.Microsecond)
+	case UnixNanoseconds:
+		value = t.UnixNano()
+	case RFC3339Timestamp:
+		value = t.In(e.zone).Format(RFC3339TimestampLayout)
+	case LocalTimestamp:
+		value = t.In(e.zone).Format(LocalTimestampLayout)
+	default:
+		return js, nil
+	}
+
+	// the field is replaced where it is, so the fields keep the order of the message
+	fields, err := splitJsonObject(js)
+	if err != nil {
+		return nil, err
+	}
+	i := fieldIndex(fields, field)
+	if i < 0 {
+		return js, nil
+	}
+	if fields[i].value, err = json.Marshal(value); err != nil {
+		return nil, err
+	}
+
+	return joinJsonObject(fields)
+}
+
+// deviceZone picks a zone of the device between UTC-12:00 and UTC+14:00 in steps of 15 minutes
+func deviceZone(orgId string, deviceKey string) *time.Location {
+	h := fnv.New32a()
+	h.Write([]byte(orgId + "/" + deviceKey))
+	quarters := int(h.Sum32()%105) - 48
+
+	return time.FixedZone("", quarters*15*60)
+}
+
+// formatTime makes the event of the device render its time in the timestamp format of the run
+func (org *Org) formatTime(d device, e Event) Event {
+	if org.Behavior == nil || org.Behavior.TimestampFormat == "" || org.Behavior.TimestampFormat == UnixSeconds {
+		return e
+	}
+	if _, ok := e.(timestamped); !ok {
+		return e
+	}
+
+	event := &formattedTimeEvent{
+		Event:  e,
+		format: org.Behavior.TimestampFormat,
+	}
+	if event.format == RFC3339Timestamp || event.format == LocalTimestamp {
+		event.zone = deviceZone(org.OrgId, d.Key())
+	}
+
+	return event
+}
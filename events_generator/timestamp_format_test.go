@@ -0,0 +1,50 @@
+package events_generator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormattedTimeEvent(t *testing.T) {
+	message := &temperatureReadingMessage{
+		deviceMessage: deviceMessage{DeviceId: 3, Time: 1540000000, nanos: 123456789},
+		DeviceName:    "device_3",
+		Temperature:   21,
+	}
+	zone := time.FixedZone("", 3*60*60+30*60)
+
+	tests := []struct {
+		format   TimestampFormat
+		wantJson string
+		wantTime time.Time
+	}{
+		{UnixMilliseconds, `{"device_id":3,"time":1540000000123,"device_name":"device_3","temp":21}`,
+			time.Unix(1540000000, 123000000)},
+		{UnixMicroseconds, `{"device_id":3,"time":1540000000123456,"device_name":"device_3","temp":21}`,
+			time.Unix(1540000000, 123456000)},
+		{UnixNanoseconds, `{"device_id":3,"time":1540000000123456789,"device_name":"device_3","temp":21}`,
+			time.Unix(1540000000, 123456789)},
+		{RFC3339Timestamp, `{"device_id":3,"time":"2018-10-20T05:16:40.123+03:30","device_name":"device_3","temp":21}`,
+			time.Unix(1540000000, 123000000)},
+		{LocalTimestamp, `{"device_id":3,"time":"2018-10-20 05:16:40","device_name":"device_3","temp":21}`,
+			time.Unix(1540000000, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			e := &formattedTimeEvent{Event: message, format: tt.format, zone: zone}
+
+			js, err := e.ToJson()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(js) != tt.wantJson {
+				t.Errorf("got %s, want %s", js, tt.wantJson)
+			}
+
+			if got, _ := EventTime(e); !got.Equal(tt.wantTime) {
+				t.Errorf("event time is %s, want %s", got.Format(time.RFC3339Nano), tt.wantTime.Format(time.RFC3339Nano))
+			}
+		})
+	}
+}
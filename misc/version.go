@@ -0,0 +1,4 @@
+package misc
+
+// Version of the generator, it's set at build time with -ldflags "-X github.com/melan/gen-events/misc.Version=..."
+var Version = "dev"
@@ -6,21 +6,69 @@ import (
 	"time"
 
 	"github.com/a8m/kinesis-producer"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/melan/gen-events/events_generator"
+	"github.com/melan/gen-events/misc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	a8mRetryBaseDelay = 100 * time.Millisecond
+	a8mRetryMaxDelay  = 5 * time.Second
+)
+
+var (
+	a8mFailedRecordsCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: misc.MetricsPrefix,
+			Name:      "a8m_failed_records_count",
+			Help:      "Number of records reported as failed by a8m producer",
+		},
+		[]string{"stream"})
+
+	a8mRetriedRecordsCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: misc.MetricsPrefix,
+			Name:      "a8m_retried_records_count",
+			Help:      "Number of failed records put back into a8m producer",
+		},
+		[]string{"stream"})
+)
+
+// a8mRetry is an event failed by the producer. Retries put it into the stream directly, so it isn't aggregated
+// once again, and it carries its attempts from one retry to another
+type a8mRetry struct {
+	data            []byte
+	partitionKey    string
+	explicitHashKey string
+	attempts        int
+}
+
 type a8mEventsPublisher struct {
 	publisher        *producer.Producer
+	client           producer.Putter
 	kinesisPublisher EventsPublisher
+	kinesisStream    string
+	deadLetters      *DeadLetterSink
+	maxRetries       int
 	ctx              context.Context
 	cancel           context.CancelFunc
+	stopOnce         sync.Once
+
+	lock    sync.Mutex
+	stopped bool
+	// pending counts scheduled retries, retriesDone is signaled when a retry is over
+	pending     int
+	retriesDone *sync.Cond
 }
 
-func (p *a8mEventsPublisher) Init() error {
-	if err := p.kinesisPublisher.Init(); err != nil {
-		return err
+func (p *a8mEventsPublisher) Init(beforeCreate func() error) (bool, error) {
+	created, err := p.kinesisPublisher.Init(beforeCreate)
+	if err != nil {
+		return created, err
 	}
 
 	go func() {
@@ -28,39 +76,168 @@ func (p *a8mEventsPublisher) Init() error {
 			select {
 			case <-p.ctx.Done():
 				return
-			case r := <-p.publisher.NotifyFailures():
-				p.publisher.Put(r.Data, r.PartitionKey)
+			case r, ok := <-p.publisher.NotifyFailures():
+				if !ok {
+					return
+				}
+				p.retry(r.Data, r.PartitionKey, r.Error())
 			}
 		}
 	}()
 
 	p.publisher.Start()
 
-	return nil
+	return created, nil
+}
+
+// retry schedules events of the failed record, which is aggregated unless it's too big for an aggregate
+func (p *a8mEventsPublisher) retry(data []byte, partitionKey string, reason string) {
+	retries := make([]*a8mRetry, 0, 1)
+	if userRecords, aggregated := Deaggregate(data); aggregated {
+		for _, userRecord := range userRecords {
+			retries = append(retries, &a8mRetry{
+				data:            userRecord.Data,
+				partitionKey:    userRecord.PartitionKey,
+				explicitHashKey: userRecord.ExplicitHashKey,
+				attempts:        1,
+			})
+		}
+	} else {
+		retries = append(retries, &a8mRetry{data: data, partitionKey: partitionKey, attempts: 1})
+	}
+
+	a8mFailedRecordsCounter.WithLabelValues(p.kinesisStream).Add(float64(len(retries)))
+	for _, retry := range retries {
+		p.schedule(retry, reason)
+	}
+}
+
+func (p *a8mEventsPublisher) schedule(retry *a8mRetry, reason string) {
+	p.lock.Lock()
+	stopped := p.stopped
+	exhausted := retry.attempts > p.maxRetries
+	if !stopped && !exhausted {
+		p.pending++
+	}
+	p.lock.Unlock()
+
+	if stopped {
+		p.deadLetter(retry, "producer is stopped: "+reason)
+		return
+	}
+	if exhausted {
+		p.deadLetter(retry, "retries are exhausted: "+reason)
+		return
+	}
+
+	delay := a8mRetryBaseDelay << uint(retry.attempts-1)
+	if delay > a8mRetryMaxDelay {
+		delay = a8mRetryMaxDelay
+	}
+
+	time.AfterFunc(delay, func() {
+		defer p.retryDone()
+
+		a8mRetriedRecordsCounter.WithLabelValues(p.kinesisStream).Inc()
+		if reason, ok := p.put(retry); !ok {
+			a8mFailedRecordsCounter.WithLabelValues(p.kinesisStream).Inc()
+			retry.attempts++
+			p.schedule(retry, reason)
+		}
+	})
+}
+
+// put sends the event with its original partition and hash keys. It returns the reason if the event wasn't accepted
+func (p *a8mEventsPublisher) put(retry *a8mRetry) (string, bool) {
+	entry := &kinesis.PutRecordsRequestEntry{
+		Data:         retry.data,
+		PartitionKey: aws.String(retry.partitionKey),
+	}
+	if retry.explicitHashKey != "" {
+		entry.ExplicitHashKey = aws.String(retry.explicitHashKey)
+	}
+
+	res, err := p.client.PutRecords(&kinesis.PutRecordsInput{
+		StreamName: aws.String(p.kinesisStream),
+		Records:    []*kinesis.PutRecordsRequestEntry{entry},
+	})
+	if err != nil {
+		return "can't put record back: " + err.Error(), false
+	}
+	if len(res.Records) > 0 && res.Records[0].ErrorCode != nil {
+		return aws.StringValue(res.Records[0].ErrorCode) + ": " + aws.StringValue(res.Records[0].ErrorMessage), false
+	}
+
+	return "", true
+}
+
+func (p *a8mEventsPublisher) retryDone() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.pending--
+	p.retriesDone.Broadcast()
+}
+
+func (p *a8mEventsPublisher) waitRetries() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for p.pending > 0 {
+		p.retriesDone.Wait()
+	}
+}
+
+func (p *a8mEventsPublisher) deadLetter(retry *a8mRetry, reason string) {
+	p.deadLetters.Send(DeadLetter{
+		Stream:       p.kinesisStream,
+		PartitionKey: retry.partitionKey,
+		Reason:       reason,
+		Attempts:     retry.attempts,
+		Data:         retry.data,
+	})
 }
 
 func (p *a8mEventsPublisher) Publish(events []events_generator.Event) {
 	for _, e := range events {
 		jsEvent, err := e.ToJson()
 		if err != nil {
+			p.deadLetters.SendEvent(p.kinesisStream, e, nil, 0, "can't serialize event: "+err.Error())
 			continue
 		}
-		p.publisher.Put(jsEvent, e.PartitionKey())
+		if err := p.publisher.Put(jsEvent, e.PartitionKey()); err != nil {
+			p.deadLetters.SendEvent(p.kinesisStream, e, jsEvent, 0, "can't put record: "+err.Error())
+		}
 	}
 }
 
+// Flush waits for scheduled retries and stops the producer to drain its buffer, so nothing can be published after it.
+// Records failing while the producer is stopping are dead-lettered right away
+func (p *a8mEventsPublisher) Flush() {
+	p.stopOnce.Do(func() {
+		p.waitRetries()
+
+		p.lock.Lock()
+		p.stopped = true
+		p.lock.Unlock()
+
+		p.publisher.Stop()
+	})
+}
+
 func (p *a8mEventsPublisher) Cleanup(g *sync.WaitGroup) {
+	p.Flush()
 	p.cancel()
-	p.publisher.Stop()
 	p.kinesisPublisher.Cleanup(g)
 }
 
-func NewA8mKinesisPublisher(client *kinesis.Kinesis, kinesisStream string, shards int64, tags map[string]*string) EventsPublisher {
+func NewA8mKinesisPublisher(client *kinesis.Kinesis, kinesisStream string, shards int64, tags map[string]*string,
+	deadLetters *DeadLetterSink, maxRetries int) EventsPublisher {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	var putter producer.Putter = client
+	var putter producer.Putter = &shardStatsPutter{Putter: client, stream: kinesisStream}
 
-	return &a8mEventsPublisher{
+	p := &a8mEventsPublisher{
 		publisher: producer.New(&producer.Config{
 			StreamName:    kinesisStream,
 			BacklogCount:  2000,
@@ -68,11 +245,39 @@ func NewA8mKinesisPublisher(client *kinesis.Kinesis, kinesisStream string, shard
 			FlushInterval: guessIntervalSec(shards),
 			Logger:        log.WithField("stream", kinesisStream),
 		}),
-		kinesisPublisher: NewKinesisEventsPublisher(client, kinesisStream, shards, tags),
+		client:           putter,
+		kinesisPublisher: NewKinesisEventsPublisher(client, kinesisStream, shards, tags, deadLetters),
+		kinesisStream:    kinesisStream,
+		deadLetters:      deadLetters,
+		maxRetries:       maxRetries,
 		ctx:              ctx,
 		cancel:           cancel,
 	}
+	p.retriesDone = sync.NewCond(&p.lock)
+
+	return p
+}
+
+// shardStatsPutter records which shards records put by the producer land in
+type shardStatsPutter struct {
+	producer.Putter
+	stream string
+}
+
+func (p *shardStatsPutter) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	res, err := p.Putter.PutRecords(input)
+	if err != nil {
+		return res, err
+	}
+
+	for i, record := range res.Records {
+		if record.ShardId != nil && i < len(input.Records) {
+			entry := input.Records[i]
+			recordShardUsage(p.stream, *record.ShardId, len(entry.Data)+len(aws.StringValue(entry.PartitionKey)))
+		}
+	}
 
+	return res, nil
 }
 
 func guessIntervalSec(shards int64) time.Duration {
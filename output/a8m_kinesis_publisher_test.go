@@ -0,0 +1,83 @@
+package output
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// failingPutter rejects the first failures puts of every record
+type failingPutter struct {
+	lock     sync.Mutex
+	failures int
+	attempts map[string]int
+	accepted []UserRecord
+}
+
+func (p *failingPutter) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	res := &kinesis.PutRecordsOutput{}
+	for _, entry := range input.Records {
+		p.attempts[string(entry.Data)]++
+		if p.attempts[string(entry.Data)] <= p.failures {
+			res.Records = append(res.Records, &kinesis.PutRecordsResultEntry{
+				ErrorCode:    aws.String("ProvisionedThroughputExceededException"),
+				ErrorMessage: aws.String("slow down"),
+			})
+			continue
+		}
+
+		p.accepted = append(p.accepted, UserRecord{
+			Data:            entry.Data,
+			PartitionKey:    aws.StringValue(entry.PartitionKey),
+			ExplicitHashKey: aws.StringValue(entry.ExplicitHashKey),
+		})
+		res.Records = append(res.Records, &kinesis.PutRecordsResultEntry{ShardId: aws.String("shardId-000000000000")})
+	}
+
+	return res, nil
+}
+
+func TestA8mRetry(t *testing.T) {
+	events := []UserRecord{
+		{Data: []byte(`{"device_id":1}`), PartitionKey: "1"},
+		{Data: []byte(`{"device_id":1}`), PartitionKey: "1"}, // an exact duplicate
+		{Data: []byte(`{"device_id":2}`), PartitionKey: "2", ExplicitHashKey: "42"},
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		failures int
+		want     []UserRecord
+	}{
+		{"aggregated record is put event by event", aggregate(events), 0, events},
+		{"plain record", []byte(`{"device_id":3}`), 1, []UserRecord{{Data: []byte(`{"device_id":3}`), PartitionKey: "3"}}},
+		{"retries are exhausted", []byte(`{"device_id":4}`), 3, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			putter := &failingPutter{failures: tt.failures, attempts: make(map[string]int)}
+			p := &a8mEventsPublisher{client: putter, kinesisStream: "stream", maxRetries: 3}
+			p.retriesDone = sync.NewCond(&p.lock)
+
+			p.retry(tt.data, "3", "failed")
+			p.waitRetries()
+
+			// retries run concurrently
+			sort.Slice(putter.accepted, func(i, j int) bool {
+				return string(putter.accepted[i].Data) < string(putter.accepted[j].Data)
+			})
+			if !reflect.DeepEqual(putter.accepted, tt.want) {
+				t.Errorf("accepted %+v, want %+v", putter.accepted, tt.want)
+			}
+		})
+	}
+}
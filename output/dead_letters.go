@@ -0,0 +1,213 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/melan/gen-events/events_generator"
+	"github.com/melan/gen-events/misc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+const DeadLettersSuffix = ".dead_letters"
+
+var deadLettersCounter = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: misc.MetricsPrefix,
+		Name:      "dead_letters_count",
+		Help:      "Number of events which could not be delivered",
+	},
+	[]string{"stream"})
+
+// DeadLetter is an event which could not be delivered. Data is empty if the event couldn't be serialized
+type DeadLetter struct {
+	Stream       string `json:"stream"`
+	PartitionKey string `json:"partition_key"`
+	Reason       string `json:"reason"`
+	Timestamp    int64  `json:"timestamp"`
+	Attempts     int    `json:"attempts"`
+	Data         []byte `json:"data,omitempty"`
+}
+
+// DeadLetterSink appends dead letters into a file per stream. A nil sink only counts and logs dead letters
+type DeadLetterSink struct {
+	dir   string
+	lock  sync.Mutex
+	files map[string]*os.File
+}
+
+func NewDeadLetterSink(dir string) (*DeadLetterSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("can't create dead letters directory %s: %s", dir, err)
+	}
+
+	return &DeadLetterSink{
+		dir:   dir,
+		files: make(map[string]*os.File),
+	}, nil
+}
+
+func DeadLettersFile(dir string, stream string) string {
+	return filepath.Join(dir, stream+DeadLettersSuffix)
+}
+
+func (s *DeadLetterSink) Send(letter DeadLetter) {
+	deadLettersCounter.WithLabelValues(letter.Stream).Inc()
+	if letter.Timestamp == 0 {
+		letter.Timestamp = time.Now().Unix()
+	}
+
+	if s == nil {
+		log.Debugf("dropping event for %s with partition key %s: %s", letter.Stream, letter.PartitionKey, letter.Reason)
+		return
+	}
+
+	js, err := json.Marshal(letter)
+	if err != nil {
+		log.WithError(err).Errorf("can't serialize dead letter for %s", letter.Stream)
+		return
+	}
+	js = append(js, newLineBytes...)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	f, ok := s.files[letter.Stream]
+	if !ok {
+		f, err = os.OpenFile(DeadLettersFile(s.dir, letter.Stream), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.WithError(err).Errorf("can't open dead letters file for %s", letter.Stream)
+			return
+		}
+		s.files[letter.Stream] = f
+	}
+
+	if _, err := f.Write(js); err != nil {
+		log.WithError(err).Errorf("can't write dead letter for %s", letter.Stream)
+	}
+}
+
+// SendEvent dead-letters an event which was generated but never delivered
+func (s *DeadLetterSink) SendEvent(stream string, e events_generator.Event, data []byte, attempts int, reason string) {
+	if replayed, ok := e.(*replayedEvent); ok {
+		attempts += replayed.attempts
+	}
+
+	s.Send(DeadLetter{
+		Stream:       stream,
+		PartitionKey: e.PartitionKey(),
+		Reason:       reason,
+		Attempts:     attempts,
+		Data:         data,
+	})
+}
+
+func (s *DeadLetterSink) Close() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for stream, f := range s.files {
+		if err := f.Close(); err != nil {
+			log.WithError(err).Errorf("can't close dead letters file for %s", stream)
+		}
+		delete(s.files, stream)
+	}
+}
+
+// ReadDeadLetters reads all dead letters from a file written by DeadLetterSink
+func ReadDeadLetters(fileName string) ([]DeadLetter, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	letters := make([]DeadLetter, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var letter DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			return nil, fmt.Errorf("can't parse dead letter in %s: %s", fileName, err)
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters, scanner.Err()
+}
+
+// WriteDeadLetters replaces the file with the dead letters. The file is written aside and renamed,
+// so it has either old or new letters if the tool crashes
+func WriteDeadLetters(fileName string, letters []DeadLetter) error {
+	var content []byte
+	for _, letter := range letters {
+		js, err := json.Marshal(letter)
+		if err != nil {
+			return fmt.Errorf("can't serialize dead letter for %s: %s", letter.Stream, err)
+		}
+		content = append(content, js...)
+		content = append(content, newLineBytes...)
+	}
+
+	tmp := fileName + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fileName)
+}
+
+// replayedEvent is a dead letter on its way back to the stream
+type replayedEvent struct {
+	data         []byte
+	partitionKey string
+	attempts     int
+}
+
+func (e *replayedEvent) PartitionKey() string {
+	return e.partitionKey
+}
+
+func (e *replayedEvent) ToJson() ([]byte, error) {
+	return e.data, nil
+}
+
+// Event returns the dead letter as an event to publish it again, or nil if the original event was never serialized
+func (l *DeadLetter) Event() events_generator.Event {
+	if len(l.Data) == 0 {
+		return nil
+	}
+
+	return &replayedEvent{
+		data:         l.Data,
+		partitionKey: l.PartitionKey,
+		attempts:     l.Attempts,
+	}
+}
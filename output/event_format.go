@@ -0,0 +1,194 @@
+package output
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/melan/gen-events/events_generator"
+)
+
+type EventFormat string
+
+const (
+	RawFormat         EventFormat = "raw"
+	EnvelopeFormat    EventFormat = "envelope"
+	CloudEventsFormat EventFormat = "cloudevents"
+)
+
+var AllEventFormats = []EventFormat{RawFormat, EnvelopeFormat, CloudEventsFormat}
+
+const (
+	EnvelopeSchemaVersion  = "1"
+	CloudEventsSpecVersion = "1.0"
+)
+
+// envelope wraps the generated event. EmittedAt is unix time in milliseconds when the event was handed to the publisher
+type envelope struct {
+	EventId       string                `json:"event_id"`
+	Seq           uint64                `json:"seq"`
+	OrgId         string                `json:"org_id"`
+	Case          events_generator.Case `json:"case"`
+	EmittedAt     int64                 `json:"emitted_at"`
+	SchemaVersion string                `json:"schema_version"`
+	// DataVersion is the version of the schema of the data if it evolves
+	DataVersion int             `json:"data_version,omitempty"`
+	Data        json.RawMessage `json:"data"`
+}
+
+type envelopedEvent struct {
+	events_generator.Event
+	envelope envelope
+}
+
+func (e *envelopedEvent) Unwrap() events_generator.Event {
+	return e.Event
+}
+
+func (e *envelopedEvent) ToJson() ([]byte, error) {
+	data, err := e.Event.ToJson()
+	if err != nil {
+		return nil, err
+	}
+
+	env := e.envelope
+	env.Data = data
+	return json.Marshal(env)
+}
+
+// cloudEvent is a CloudEvents structured mode event. Sequence is the sequence extension attribute
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Id              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	Sequence        string          `json:"sequence,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+type cloudEventsEvent struct {
+	events_generator.Event
+	cloudEvent cloudEvent
+}
+
+func (e *cloudEventsEvent) Unwrap() events_generator.Event {
+	return e.Event
+}
+
+func (e *cloudEventsEvent) ToJson() ([]byte, error) {
+	data, err := e.Event.ToJson()
+	if err != nil {
+		return nil, err
+	}
+
+	ce := e.cloudEvent
+	ce.Data = data
+	return json.Marshal(ce)
+}
+
+type eventFormatPublisher struct {
+	EventsPublisher
+	format EventFormat
+	stream string
+	orgId  string
+	caseId events_generator.Case
+	// salt keeps ids of events unique across runs
+	salt string
+}
+
+// eventId identifies the event. Numbered events of a device get the same id every time they are emitted,
+// so injected duplicates can be recognized by it
+func (p *eventFormatPublisher) eventId(e events_generator.Event, orgId string, caseId events_generator.Case,
+	seq uint64, sequenced bool) string {
+	if !sequenced {
+		return newUUID()
+	}
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%s/%s/%s/%s/%d", p.salt, orgId, caseId, events_generator.DeviceKey(e), seq)
+	b := h.Sum(nil)[:16]
+	b[6] = (b[6] & 0x0f) | 0x50 // version 5
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (p *eventFormatPublisher) Publish(events []events_generator.Event) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	formatted := make([]events_generator.Event, 0, len(events))
+	for _, e := range events {
+		orgId, caseId, ok := events_generator.Tenant(e)
+		if !ok {
+			orgId, caseId = p.orgId, p.caseId
+		}
+		seq, sequenced := events_generator.Sequence(e)
+		eventId := p.eventId(e, orgId, caseId, seq, sequenced)
+
+		switch p.format {
+		case CloudEventsFormat:
+			ce := cloudEvent{
+				SpecVersion:     CloudEventsSpecVersion,
+				Id:              eventId,
+				Source:          "/streams/" + url.PathEscape(p.stream) + "/orgs/" + url.PathEscape(orgId),
+				Type:            string(caseId),
+				Subject:         events_generator.DeviceKey(e),
+				DataContentType: "application/json",
+			}
+			if eventTime, ok := events_generator.EventTime(e); ok {
+				ce.Time = eventTime.UTC().Format(time.RFC3339Nano)
+			}
+			if version, ok := events_generator.SchemaVersion(e); ok {
+				ce.DataSchema = "urn:gen-events:schema:" + string(caseId) + ":" + strconv.Itoa(version)
+			}
+			if sequenced {
+				ce.Sequence = strconv.FormatUint(seq, 10)
+			}
+			formatted = append(formatted, &cloudEventsEvent{Event: e, cloudEvent: ce})
+		default:
+			enveloped := &envelopedEvent{
+				Event: e,
+				envelope: envelope{
+					EventId:       eventId,
+					Seq:           seq,
+					OrgId:         orgId,
+					Case:          caseId,
+					EmittedAt:     now,
+					SchemaVersion: EnvelopeSchemaVersion,
+				},
+			}
+			if version, ok := events_generator.SchemaVersion(e); ok {
+				enveloped.envelope.DataVersion = version
+			}
+			formatted = append(formatted, enveloped)
+		}
+	}
+
+	p.EventsPublisher.Publish(formatted)
+}
+
+// WithEventFormat decorates publishers created by the factory to wrap events into the format. Orgs have to number
+// events of their devices for sequence numbers of EnvelopeFormat and CloudEventsFormat
+func WithEventFormat(factory PublisherFactory, format EventFormat) PublisherFactory {
+	if format == "" || format == RawFormat {
+		return factory
+	}
+
+	salt := newUUID()
+	return func(org *events_generator.Org) EventsPublisher {
+		return &eventFormatPublisher{
+			EventsPublisher: factory(org),
+			format:          format,
+			stream:          org.StreamName(),
+			orgId:           org.OrgId,
+			caseId:          org.CaseId,
+			salt:            salt,
+		}
+	}
+}
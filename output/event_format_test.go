@@ -0,0 +1,55 @@
+package output
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/melan/gen-events/events_generator"
+)
+
+type deviceEvent string
+
+func (e deviceEvent) PartitionKey() string {
+	return string(e)
+}
+
+func (e deviceEvent) ToJson() ([]byte, error) {
+	return []byte(`{}`), nil
+}
+
+func TestEventId(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	p := &eventFormatPublisher{salt: "run"}
+	id := func(salt, orgId, device string, seq uint64) string {
+		p := &eventFormatPublisher{salt: salt}
+		return p.eventId(deviceEvent(device), orgId, events_generator.CaseOne, seq, true)
+	}
+	first := id("run", "1", "7", 1)
+
+	tests := []struct {
+		name string
+		id   string
+		same bool
+	}{
+		{"same event", id("run", "1", "7", 1), true},
+		{"next number", id("run", "1", "7", 2), false},
+		{"another device", id("run", "1", "8", 1), false},
+		{"another org", id("run", "2", "7", 1), false},
+		{"another run", id("rerun", "1", "7", 1), false},
+		{"unnumbered event", p.eventId(deviceEvent("7"), "1", events_generator.CaseOne, 1, false), false},
+	}
+
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-5`).MatchString(first) {
+		t.Errorf("%s isn't a version 5 uuid", first)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !uuid.MatchString(tt.id) {
+				t.Errorf("%s isn't a uuid", tt.id)
+			}
+			if same := tt.id == first; same != tt.same {
+				t.Errorf("id %s of the event is the same as %s: %t, want %t", tt.id, first, same, tt.same)
+			}
+		})
+	}
+}
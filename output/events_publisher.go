@@ -5,29 +5,44 @@ import (
 
 	"github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/melan/gen-events/events_generator"
+	log "github.com/sirupsen/logrus"
 )
 
 type EventsPublisher interface {
-	Init() error
+	// Init creates the stream if it doesn't exist yet. beforeCreate is called, if set, right before the stream is created.
+	// created reports if the stream was created by this call and not by somebody else
+	Init(beforeCreate func() error) (created bool, err error)
 	Publish(events []events_generator.Event)
+	// Flush blocks until all published events are delivered or dead-lettered
+	Flush()
 	Cleanup(g *sync.WaitGroup)
 }
 
 type PublisherFactory func(org *events_generator.Org) EventsPublisher
 
-func CreateA8mKinesisPublisherFactory(client *kinesis.Kinesis, tags map[string]*string) PublisherFactory {
+func CreateA8mKinesisPublisherFactory(client *kinesis.Kinesis, deadLetters *DeadLetterSink, maxRetries int) PublisherFactory {
 	return func(org *events_generator.Org) EventsPublisher {
-		return NewA8mKinesisPublisher(client, org.StreamName(), org.NumberOfStreamShards(), tags)
+		return NewA8mKinesisPublisher(client, org.StreamName(), org.NumberOfStreamShards(), kinesisTags(org), deadLetters,
+			maxRetries)
 	}
 }
-func CreateKinesisPublisherFactory(client *kinesis.Kinesis, tags map[string]*string) PublisherFactory {
+func CreateKinesisPublisherFactory(client *kinesis.Kinesis, deadLetters *DeadLetterSink) PublisherFactory {
 	return func(org *events_generator.Org) EventsPublisher {
-		return NewKinesisEventsPublisher(client, org.StreamName(), org.NumberOfStreamShards(), tags)
+		return NewKinesisEventsPublisher(client, org.StreamName(), org.NumberOfStreamShards(), kinesisTags(org), deadLetters)
 	}
 }
 
-func CreateFilePublisherFactory(outputDir string) PublisherFactory {
+func CreateFilePublisherFactory(outputDir string, deadLetters *DeadLetterSink) PublisherFactory {
 	return func(org *events_generator.Org) EventsPublisher {
-		return NewFileEventsPublisher(outputDir, org)
+		return NewFileEventsPublisher(outputDir, org.StreamName(), deadLetters)
 	}
 }
+
+func kinesisTags(org *events_generator.Org) map[string]*string {
+	tags, err := org.StreamTags()
+	if err != nil {
+		log.WithError(err).Panic("can't get stream tags")
+	}
+
+	return ToKinesisTags(tags)
+}
@@ -0,0 +1,187 @@
+package output
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/melan/gen-events/events_generator"
+	"github.com/melan/gen-events/misc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var injectedFaults = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: misc.MetricsPrefix,
+		Name:      "injected_faults",
+		Help:      "Number of malformed events published on purpose by the fault type",
+	},
+	[]string{"stream", "fault"})
+
+type FaultType string
+
+const (
+	TruncatedFault    FaultType = "truncated"
+	WrongTypeFault    FaultType = "wrong_type"
+	MissingFieldFault FaultType = "missing_field"
+	InvalidUtf8Fault  FaultType = "invalid_utf8"
+	OversizedFault    FaultType = "oversized"
+	EmptyFault        FaultType = "empty"
+)
+
+var AllFaultTypes = []FaultType{TruncatedFault, WrongTypeFault, MissingFieldFault, InvalidUtf8Fault, OversizedFault,
+	EmptyFault}
+
+// maxRecordSize is the largest record Kinesis accepts, 1 MiB
+const maxRecordSize = 1 << 20
+
+type faultyEvent struct {
+	events_generator.Event
+	fault FaultType
+	// pick makes the event corrupted the same way every time it's serialized
+	pick int
+}
+
+func (e *faultyEvent) Unwrap() events_generator.Event {
+	return e.Event
+}
+
+func (e *faultyEvent) ToJson() ([]byte, error) {
+	js, err := e.Event.ToJson()
+	if err != nil {
+		return nil, err
+	}
+
+	return corrupt(js, e.fault, e.pick), nil
+}
+
+// faultOf returns the fault type if the event is published malformed on purpose
+func faultOf(e events_generator.Event) (FaultType, bool) {
+	found := events_generator.FindEvent(e, func(e events_generator.Event) bool {
+		_, ok := e.(*faultyEvent)
+		return ok
+	})
+	if found == nil {
+		return "", false
+	}
+
+	return found.(*faultyEvent).fault, true
+}
+
+// corrupt turns the serialized event into a malformed one of the fault type. pick chooses where it's truncated
+// or which field is missing
+func corrupt(js []byte, fault FaultType, pick int) []byte {
+	switch fault {
+	case TruncatedFault:
+		if len(js) < 2 {
+			return js
+		}
+		return js[:1+pick%(len(js)-1)]
+	case WrongTypeFault:
+		var fields map[string]interface{}
+		if err := json.Unmarshal(js, &fields); err != nil {
+			return js
+		}
+		changed, _ := json.Marshal(changeTypes(fields))
+		return changed
+	case MissingFieldFault:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(js, &fields); err != nil || len(fields) == 0 {
+			return js
+		}
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		delete(fields, keys[pick%len(keys)])
+		changed, _ := json.Marshal(fields)
+		return changed
+	case InvalidUtf8Fault:
+		i := strings.IndexByte(string(js), '"') // the first key gets bytes which can't be in UTF-8
+		if i < 0 {
+			return append(js, 0xff, 0xfe)
+		}
+		corrupted := make([]byte, 0, len(js)+2)
+		corrupted = append(corrupted, js[:i+1]...)
+		corrupted = append(corrupted, 0xff, 0xfe)
+		return append(corrupted, js[i+1:]...)
+	case OversizedFault:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(js, &fields); err != nil {
+			return js
+		}
+		padding, _ := json.Marshal(strings.Repeat("x", maxRecordSize))
+		fields["padding"] = padding
+		changed, _ := json.Marshal(fields)
+		return changed
+	case EmptyFault:
+		return []byte{}
+	default:
+		return js
+	}
+}
+
+// changeTypes replaces numbers with strings and strings, booleans and nulls with numbers
+func changeTypes(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, field := range value {
+			value[k] = changeTypes(field)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = changeTypes(item)
+		}
+		return value
+	case float64:
+		b, _ := json.Marshal(value)
+		return string(b)
+	case string:
+		return len(value)
+	default:
+		return 0
+	}
+}
+
+type faultPublisher struct {
+	EventsPublisher
+	stream string
+	rate   float64
+	types  []FaultType
+}
+
+func (p *faultPublisher) Publish(events []events_generator.Event) {
+	published := make([]events_generator.Event, 0, len(events))
+	for _, e := range events {
+		if rand.Float64() < p.rate {
+			fault := p.types[rand.Intn(len(p.types))]
+			injectedFaults.WithLabelValues(p.stream, string(fault)).Inc()
+			e = &faultyEvent{Event: e, fault: fault, pick: rand.Int()}
+		}
+		published = append(published, e)
+	}
+
+	p.EventsPublisher.Publish(published)
+}
+
+// WithFaults decorates publishers created by the factory to publish the share of events malformed by one of the types.
+// It has to be applied on top of WithGroundTruth, so faults are recorded, and under WithEventFormat to corrupt
+// whole records
+func WithFaults(factory PublisherFactory, rate float64, types []FaultType) PublisherFactory {
+	if rate <= 0 || len(types) == 0 {
+		return factory
+	}
+
+	return func(org *events_generator.Org) EventsPublisher {
+		return &faultPublisher{
+			EventsPublisher: factory(org),
+			stream:          org.StreamName(),
+			rate:            rate,
+			types:           types,
+		}
+	}
+}
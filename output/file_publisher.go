@@ -2,6 +2,7 @@ package output
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
@@ -13,11 +14,33 @@ import (
 var newLineBytes = []byte("\n")
 
 type FileEventsPublisher struct {
-	fileName string
+	fileName    string
+	stream      string
+	deadLetters *DeadLetterSink
 }
 
-func (p *FileEventsPublisher) Init() error {
-	return nil
+// Init creates an empty file if it doesn't exist, events of resumed runs are appended to existing files
+func (p *FileEventsPublisher) Init(beforeCreate func() error) (bool, error) {
+	if _, err := os.Stat(p.fileName); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if beforeCreate != nil {
+		if err := beforeCreate(); err != nil {
+			return false, fmt.Errorf("can't create file %s: %s", p.fileName, err)
+		}
+	}
+	f, err := os.OpenFile(p.fileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, f.Close()
 }
 
 func (p *FileEventsPublisher) Publish(events []events_generator.Event) {
@@ -31,6 +54,7 @@ func (p *FileEventsPublisher) Publish(events []events_generator.Event) {
 		js, err := event.ToJson()
 		if err != nil {
 			log.Printf("can't serialize event %#v. skipping", event)
+			p.deadLetters.SendEvent(p.stream, event, nil, 0, "can't serialize event: "+err.Error())
 			continue
 		}
 
@@ -39,7 +63,7 @@ func (p *FileEventsPublisher) Publish(events []events_generator.Event) {
 
 	var batch []byte
 	batch = bytes.Join(jsons, []byte("\n"))
-	if len(batch) > 0 {
+	if len(jsons) > 0 {
 		batch = append(batch, "\n"...)
 	}
 	_, err = f.Write(batch)
@@ -51,14 +75,19 @@ func (p *FileEventsPublisher) Publish(events []events_generator.Event) {
 	defer f.Close()
 }
 
+func (p *FileEventsPublisher) Flush() {
+}
+
 func (p *FileEventsPublisher) Cleanup(g *sync.WaitGroup) {
 	log.Printf("cleanup for %s is done", p.fileName)
 	g.Done()
 }
 
-func NewFileEventsPublisher(outputPath string, org *events_generator.Org) EventsPublisher {
-	filePath := filepath.Join(outputPath, org.StreamName())
+func NewFileEventsPublisher(outputPath string, stream string, deadLetters *DeadLetterSink) EventsPublisher {
+	filePath := filepath.Join(outputPath, stream)
 	return &FileEventsPublisher{
-		fileName: filePath,
+		fileName:    filePath,
+		stream:      stream,
+		deadLetters: deadLetters,
 	}
 }
@@ -0,0 +1,185 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/melan/gen-events/events_generator"
+	log "github.com/sirupsen/logrus"
+)
+
+const GroundTruthSuffix = ".ground_truth"
+
+// GroundTruthEntry describes an event handed to a publisher. Hash identifies the serialized event in the stream
+type GroundTruthEntry struct {
+	Hash string `json:"hash"`
+	// Device is prefixed with the org id and '/' in shared streams
+	Device      string `json:"device"`
+	PublishedAt int64  `json:"published_at"` // unix time in milliseconds
+	// Seq is the sequence number of the event of the device, 0 if events aren't numbered
+	Seq uint64 `json:"seq,omitempty"`
+	// Duplicate is the kind of the duplicate if the event was injected as a duplicate of another one
+	Duplicate events_generator.DuplicateKind `json:"duplicate,omitempty"`
+	// Fault is the type of the fault if the event was published malformed on purpose
+	Fault FaultType `json:"fault,omitempty"`
+}
+
+// GroundTruthSink appends entries of published events into a sidecar file per stream
+type GroundTruthSink struct {
+	dir   string
+	lock  sync.Mutex
+	files map[string]*os.File
+}
+
+func NewGroundTruthSink(dir string) (*GroundTruthSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("can't create ground truth directory %s: %s", dir, err)
+	}
+
+	return &GroundTruthSink{
+		dir:   dir,
+		files: make(map[string]*os.File),
+	}, nil
+}
+
+func GroundTruthFile(dir string, stream string) string {
+	return filepath.Join(dir, stream+GroundTruthSuffix)
+}
+
+// RecordHash identifies a serialized event
+func RecordHash(data []byte) string {
+	h := fnv.New64a()
+	h.Write(data)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func (s *GroundTruthSink) Record(stream string, entries []GroundTruthEntry) {
+	var batch []byte
+	for _, entry := range entries {
+		js, err := json.Marshal(entry)
+		if err != nil {
+			log.WithError(err).Errorf("can't serialize ground truth for %s", stream)
+			continue
+		}
+		batch = append(batch, js...)
+		batch = append(batch, newLineBytes...)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	f, ok := s.files[stream]
+	if !ok {
+		var err error
+		f, err = os.OpenFile(GroundTruthFile(s.dir, stream), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.WithError(err).Errorf("can't open ground truth file for %s", stream)
+			return
+		}
+		s.files[stream] = f
+	}
+
+	if _, err := f.Write(batch); err != nil {
+		log.WithError(err).Errorf("can't write ground truth for %s", stream)
+	}
+}
+
+func (s *GroundTruthSink) Close() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for stream, f := range s.files {
+		if err := f.Close(); err != nil {
+			log.WithError(err).Errorf("can't close ground truth file for %s", stream)
+		}
+		delete(s.files, stream)
+	}
+}
+
+// ReadGroundTruth reads all entries from a file written by GroundTruthSink
+func ReadGroundTruth(fileName string) ([]GroundTruthEntry, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make([]GroundTruthEntry, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry GroundTruthEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("can't parse ground truth in %s: %s", fileName, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+type groundTruthPublisher struct {
+	EventsPublisher
+	stream string
+	sink   *GroundTruthSink
+}
+
+func (p *groundTruthPublisher) Publish(events []events_generator.Event) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	entries := make([]GroundTruthEntry, 0, len(events))
+	for _, e := range events {
+		js, err := e.ToJson()
+		if err != nil { // the publisher dead-letters it
+			continue
+		}
+
+		device := events_generator.DeviceKey(e)
+		if orgId, _, ok := events_generator.Tenant(e); ok { // devices of different orgs share the stream
+			device = orgId + "/" + device
+		}
+		seq, _ := events_generator.Sequence(e)
+		duplicate, _ := events_generator.DuplicateOf(e)
+		fault, _ := faultOf(e)
+		entries = append(entries, GroundTruthEntry{
+			Hash:        RecordHash(js),
+			Device:      device,
+			PublishedAt: now,
+			Seq:         seq,
+			Duplicate:   duplicate,
+			Fault:       fault,
+		})
+	}
+	p.sink.Record(p.stream, entries)
+
+	p.EventsPublisher.Publish(events)
+}
+
+// WithGroundTruth decorates publishers created by the factory to record every event they publish into the sink.
+// Decorators which change events have to be applied on top of it, so it records events the way they are published
+func WithGroundTruth(factory PublisherFactory, sink *GroundTruthSink) PublisherFactory {
+	if sink == nil {
+		return factory
+	}
+
+	return func(org *events_generator.Org) EventsPublisher {
+		return &groundTruthPublisher{
+			EventsPublisher: factory(org),
+			stream:          org.StreamName(),
+			sink:            sink,
+		}
+	}
+}
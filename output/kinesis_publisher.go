@@ -46,36 +46,47 @@ type KinesisEventsPublisher struct {
 	kinesisStream string
 	shards        int64
 	tags          map[string]*string
+	deadLetters   *DeadLetterSink
+	inFlight      sync.WaitGroup
 }
 
-func NewKinesisEventsPublisher(client *kinesis.Kinesis, kinesisStream string, shards int64, tags map[string]*string) EventsPublisher {
+func NewKinesisEventsPublisher(client *kinesis.Kinesis, kinesisStream string, shards int64, tags map[string]*string,
+	deadLetters *DeadLetterSink) EventsPublisher {
 	publisher := &KinesisEventsPublisher{
 		client:        client,
 		kinesisStream: kinesisStream,
 		shards:        shards,
 		tags:          tags,
+		deadLetters:   deadLetters,
 	}
+	registerStreamShards(kinesisStream, shards)
 
 	return publisher
 }
 
-func (p *KinesisEventsPublisher) Init() error {
+func (p *KinesisEventsPublisher) Init(beforeCreate func() error) (bool, error) {
 	describeStreamInput := &kinesis.DescribeStreamInput{
 		StreamName: &p.kinesisStream,
 	}
 
+	created := false
 	for {
 		_, err := p.client.DescribeStream(describeStreamInput)
 		if err != nil {
 			awsErr, ok := err.(awserr.Error)
 			if !ok {
-				return fmt.Errorf("can't describe stream %s. Unexpected error: %s", p.kinesisStream, err.Error())
+				return created, fmt.Errorf("can't describe stream %s. Unexpected error: %s", p.kinesisStream, err.Error())
 			}
 
 			switch awsErr.Code() {
 			case "ResourceNotFoundException":
 				// create stream
-				p.createStream(p.shards)
+				if beforeCreate != nil {
+					if err := beforeCreate(); err != nil {
+						return created, fmt.Errorf("can't create stream %s: %s", p.kinesisStream, err)
+					}
+				}
+				created = p.createStream(p.shards) || created
 				for {
 					if err := p.client.WaitUntilStreamExists(describeStreamInput); err != nil {
 						log.Printf("something is wrong while waiting for stream %s/%d to be created, will check later. Error: %s",
@@ -93,17 +104,18 @@ func (p *KinesisEventsPublisher) Init() error {
 				continue
 			default:
 				// unknown error. panic
-				return fmt.Errorf("can't describe stream %s. Unexpected AWS error: %s", p.kinesisStream, err.Error())
+				return created, fmt.Errorf("can't describe stream %s. Unexpected AWS error: %s", p.kinesisStream, err.Error())
 			}
 		}
 
 		// TODO: Add some kind of resize if more/less shards than needed
-		return nil
+		return created, nil
 	}
 }
 
 func (p *KinesisEventsPublisher) Publish(events []events_generator.Event) {
 	records := make([]*kinesis.PutRecordsRequestEntry, 0, len(events))
+	priorAttempts := make(map[*kinesis.PutRecordsRequestEntry]int)
 	var totalSize int64
 
 	generatedEventsCounter.WithLabelValues(p.kinesisStream).Add(float64(len(events)))
@@ -111,15 +123,29 @@ func (p *KinesisEventsPublisher) Publish(events []events_generator.Event) {
 	for _, event := range events {
 		jsEvent, err := event.ToJson()
 		if err != nil {
+			p.deadLetters.SendEvent(p.kinesisStream, event, nil, 0, "can't serialize event: "+err.Error())
 			continue
 		}
 		partitionKey := event.PartitionKey()
+		if len(jsEvent)+len(partitionKey) > maxRecordSize { // Kinesis would reject the whole batch
+			p.deadLetters.SendEvent(p.kinesisStream, event, jsEvent, 0, "record exceeds 1 MiB")
+			continue
+		}
 		totalSize += int64(len(jsEvent) + len([]byte(partitionKey)))
 
-		records = append(records, &kinesis.PutRecordsRequestEntry{
+		record := &kinesis.PutRecordsRequestEntry{
 			Data:         jsEvent,
 			PartitionKey: &partitionKey,
-		})
+		}
+		if hashKey := explicitHashKey(event); hashKey != "" {
+			record.ExplicitHashKey = &hashKey
+		}
+
+		if replayed, ok := event.(*replayedEvent); ok {
+			priorAttempts[record] = replayed.attempts
+		}
+
+		records = append(records, record)
 	}
 
 	serializedEventsCounter.WithLabelValues(p.kinesisStream).Add(float64(len(records)))
@@ -148,12 +174,19 @@ func (p *KinesisEventsPublisher) Publish(events []events_generator.Event) {
 				batchSize += recordSize
 			}
 		}
+		if len(batch) > 0 { // the last batch isn't full
+			batches = append(batches, batch)
+		}
 	}
 
 	for _, batch := range batches {
+		p.inFlight.Add(1)
 		go func(batch []*kinesis.PutRecordsRequestEntry) {
+			defer p.inFlight.Done()
+
 			retryBatch := make([]*kinesis.PutRecordsRequestEntry, len(batch))
 			copy(retryBatch, batch)
+			attempts := 0
 
 			for len(retryBatch) > 0 { // keep trying while there is anything to post
 
@@ -162,12 +195,29 @@ func (p *KinesisEventsPublisher) Publish(events []events_generator.Event) {
 					StreamName: &p.kinesisStream,
 				}
 
+				attempts++
 				res, err := p.client.PutRecords(input)
 				if err != nil {
 					log.Printf("Can't send batch to %s - Skipping. %s", p.kinesisStream, err.Error())
+					for _, record := range retryBatch {
+						p.deadLetters.Send(DeadLetter{
+							Stream:       p.kinesisStream,
+							PartitionKey: *record.PartitionKey,
+							Reason:       "can't put records: " + err.Error(),
+							Attempts:     attempts + priorAttempts[record],
+							Data:         record.Data,
+						})
+					}
 					return
 				}
 
+				for i, record := range res.Records {
+					if record.ShardId != nil {
+						entry := retryBatch[i]
+						recordShardUsage(p.kinesisStream, *record.ShardId, len(entry.Data)+len(*entry.PartitionKey))
+					}
+				}
+
 				if res.FailedRecordCount == nil || *res.FailedRecordCount == 0 { // everything is fine
 					return
 				}
@@ -195,7 +245,12 @@ func (p *KinesisEventsPublisher) Publish(events []events_generator.Event) {
 	}
 }
 
-func (p *KinesisEventsPublisher) createStream(shardsCount int64) {
+func (p *KinesisEventsPublisher) Flush() {
+	p.inFlight.Wait()
+}
+
+// createStream reports false if the stream exists already
+func (p *KinesisEventsPublisher) createStream(shardsCount int64) bool {
 	createRequest := &kinesis.CreateStreamInput{
 		StreamName: &p.kinesisStream,
 		ShardCount: &shardsCount,
@@ -213,7 +268,7 @@ func (p *KinesisEventsPublisher) createStream(shardsCount int64) {
 			case "ResourceInUseException":
 				log.Printf("new stream %s/%d was created but it is in unknown state",
 					p.kinesisStream, shardsCount)
-				return
+				return false
 			case "LimitExceededException":
 				log.Printf("can't create a new stream %s/%d because of throttling on aws side",
 					p.kinesisStream, shardsCount)
@@ -229,12 +284,28 @@ func (p *KinesisEventsPublisher) createStream(shardsCount int64) {
 					p.kinesisStream, shardsCount, err.Error())
 			}
 		} else {
-			return
+			return true
 		}
 	}
 }
 
 func (p *KinesisEventsPublisher) setTags(tags map[string]*string) {
+	// a single request can add up to 10 tags
+	chunk := make(map[string]*string, maxTagsPerRequest)
+	for k, v := range tags {
+		chunk[k] = v
+		if len(chunk) == maxTagsPerRequest {
+			p.addTags(chunk)
+			chunk = make(map[string]*string, maxTagsPerRequest)
+		}
+	}
+
+	if len(chunk) > 0 {
+		p.addTags(chunk)
+	}
+}
+
+func (p *KinesisEventsPublisher) addTags(tags map[string]*string) {
 	tagsRequest := &kinesis.AddTagsToStreamInput{
 		StreamName: &p.kinesisStream,
 		Tags:       tags,
@@ -282,43 +353,7 @@ func (p *KinesisEventsPublisher) setTags(tags map[string]*string) {
 func (p *KinesisEventsPublisher) Cleanup(g *sync.WaitGroup) {
 	defer g.Done()
 
-	trueTrue := true
-	deleteRequest := &kinesis.DeleteStreamInput{
-		EnforceConsumerDeletion: &trueTrue,
-		StreamName:              &p.kinesisStream,
-	}
-
-	for {
-		_, err := p.client.DeleteStream(deleteRequest)
-		if err != nil {
-			awsErr, ok := err.(awserr.Error)
-			if !ok {
-				log.Printf("can't remove stream %s because of unexpected error: %s", p.kinesisStream, err.Error())
-				return
-			}
-
-			switch awsErr.Code() {
-			case "ResourceNotFoundException":
-				// stream doesn't exists
-				return
-			case "LimitExceededException":
-				log.Printf("request to delete stream %s was throttled, will try later. Error: %s",
-					p.kinesisStream, err.Error())
-				time.Sleep(100 * time.Millisecond)
-				continue
-			case "ResourceInUseException":
-				log.Printf("stream %s is busy, will try later. Error: %s",
-					p.kinesisStream, err.Error())
-				time.Sleep(100 * time.Millisecond)
-				continue
-			default:
-				log.Printf("can't remove stream %s because of unexpected AWS error: %s", p.kinesisStream, err.Error())
-			}
-		} else {
-			p.client.WaitUntilStreamNotExists(&kinesis.DescribeStreamInput{
-				StreamName: &p.kinesisStream,
-			})
-			return
-		}
+	if err := DeleteStream(p.client, p.kinesisStream); err != nil {
+		log.Print(err.Error())
 	}
 }
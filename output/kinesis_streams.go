@@ -0,0 +1,162 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeleteStream removes the stream and waits until it's gone. Missing stream isn't an error
+func DeleteStream(client *kinesis.Kinesis, stream string) error {
+	trueTrue := true
+	deleteRequest := &kinesis.DeleteStreamInput{
+		EnforceConsumerDeletion: &trueTrue,
+		StreamName:              &stream,
+	}
+
+	for {
+		_, err := client.DeleteStream(deleteRequest)
+		if err != nil {
+			awsErr, ok := err.(awserr.Error)
+			if !ok {
+				return fmt.Errorf("can't remove stream %s because of unexpected error: %s", stream, err.Error())
+			}
+
+			switch awsErr.Code() {
+			case "ResourceNotFoundException":
+				// stream doesn't exists
+				return nil
+			case "LimitExceededException":
+				log.Printf("request to delete stream %s was throttled, will try later. Error: %s",
+					stream, err.Error())
+				time.Sleep(100 * time.Millisecond)
+				continue
+			case "ResourceInUseException":
+				log.Printf("stream %s is busy, will try later. Error: %s",
+					stream, err.Error())
+				time.Sleep(100 * time.Millisecond)
+				continue
+			default:
+				return fmt.Errorf("can't remove stream %s because of unexpected AWS error: %s", stream, err.Error())
+			}
+		} else {
+			return client.WaitUntilStreamNotExists(&kinesis.DescribeStreamInput{
+				StreamName: &stream,
+			})
+		}
+	}
+}
+
+// ListStreams returns names of all streams starting with the prefix and having all the tags
+func ListStreams(client *kinesis.Kinesis, prefix string, tags map[string]string) ([]string, error) {
+	streams := make([]string, 0)
+	input := &kinesis.ListStreamsInput{}
+	if len(prefix) > 1 {
+		// streams are listed in alphabetical order, so skip everything before the prefix. The start is exclusive,
+		// so it's cut by a character to keep the stream named as the prefix itself
+		start := prefix[:len(prefix)-1]
+		input.ExclusiveStartStreamName = &start
+	}
+
+	for {
+		res, err := client.ListStreams(input)
+		if err != nil {
+			if isThrottled(err) {
+				log.Printf("request to list streams was throttled, will try later. Error: %s", err.Error())
+				time.Sleep(1 * time.Second)
+				continue
+			}
+			return nil, fmt.Errorf("can't list streams: %s", err.Error())
+		}
+
+		lastName := ""
+		for _, name := range res.StreamNames {
+			lastName = *name
+			if prefix != "" && !strings.HasPrefix(*name, prefix) {
+				if *name > prefix { // all streams with the prefix are listed already
+					return filterStreamsByTags(client, streams, tags)
+				}
+				continue
+			}
+			streams = append(streams, *name)
+		}
+
+		if res.HasMoreStreams == nil || !*res.HasMoreStreams || lastName == "" {
+			break
+		}
+		input.ExclusiveStartStreamName = &lastName
+	}
+
+	return filterStreamsByTags(client, streams, tags)
+}
+
+func filterStreamsByTags(client *kinesis.Kinesis, streams []string, tags map[string]string) ([]string, error) {
+	if len(tags) == 0 {
+		return streams, nil
+	}
+
+	matched := make([]string, 0, len(streams))
+	for _, stream := range streams {
+		streamTags, err := listStreamTags(client, stream)
+		if err != nil {
+			return nil, err
+		}
+
+		matches := true
+		for k, v := range tags {
+			if value, ok := streamTags[k]; !ok || value != v {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			matched = append(matched, stream)
+		}
+	}
+
+	return matched, nil
+}
+
+func listStreamTags(client *kinesis.Kinesis, stream string) (map[string]string, error) {
+	tags := make(map[string]string)
+	input := &kinesis.ListTagsForStreamInput{
+		StreamName: &stream,
+	}
+
+	for {
+		res, err := client.ListTagsForStream(input)
+		if err != nil {
+			if isThrottled(err) {
+				log.Printf("request to list tags of stream %s was throttled, will try later. Error: %s", stream, err.Error())
+				time.Sleep(1 * time.Second)
+				continue
+			}
+			return nil, fmt.Errorf("can't list tags of stream %s: %s", stream, err.Error())
+		}
+
+		var lastKey string
+		for _, tag := range res.Tags {
+			lastKey = *tag.Key
+			value := ""
+			if tag.Value != nil {
+				value = *tag.Value
+			}
+			tags[*tag.Key] = value
+		}
+
+		if res.HasMoreTags == nil || !*res.HasMoreTags || lastKey == "" {
+			return tags, nil
+		}
+		input.ExclusiveStartTagKey = &lastKey
+	}
+}
+
+func isThrottled(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && (awsErr.Code() == "LimitExceededException" || awsErr.Code() == "ProvisionedThroughputExceededException")
+}
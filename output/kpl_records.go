@@ -0,0 +1,150 @@
+package output
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+)
+
+// kplMagic starts records aggregated by KPL compatible producers like a8m. The magic is followed by an AggregatedRecord
+// protobuf message and md5 of the message
+var kplMagic = []byte{0xF3, 0x89, 0x9A, 0xC2}
+
+var errMalformedProtobuf = errors.New("malformed protobuf message")
+
+// UserRecord is a record packed into an aggregated record. ExplicitHashKey is empty if the record didn't have it
+type UserRecord struct {
+	Data            []byte
+	PartitionKey    string
+	ExplicitHashKey string
+}
+
+// Deaggregate unpacks records of a KPL aggregated record. It returns false if the data isn't an aggregated record
+func Deaggregate(data []byte) ([]UserRecord, bool) {
+	if len(data) < len(kplMagic)+md5.Size || !bytes.HasPrefix(data, kplMagic) {
+		return nil, false
+	}
+
+	message := data[len(kplMagic) : len(data)-md5.Size]
+	checksum := md5.Sum(message)
+	if !bytes.Equal(checksum[:], data[len(data)-md5.Size:]) {
+		return nil, false
+	}
+
+	records, err := parseAggregatedRecord(message)
+	if err != nil {
+		return nil, false
+	}
+
+	return records, true
+}
+
+// parseAggregatedRecord decodes
+//
+//	message AggregatedRecord {
+//	  repeated string partition_key_table = 1;
+//	  repeated string explicit_hash_key_table = 2;
+//	  repeated Record records = 3;
+//	}
+//	message Record {
+//	  required uint64 partition_key_index = 1;
+//	  optional uint64 explicit_hash_key_index = 2;
+//	  required bytes data = 3;
+//	  repeated Tag tags = 4;
+//	}
+func parseAggregatedRecord(message []byte) ([]UserRecord, error) {
+	var partitionKeys, hashKeys []string
+	var records [][]byte
+	err := walkProtobuf(message, func(field uint64, value []byte, _ uint64) error {
+		switch field {
+		case 1:
+			partitionKeys = append(partitionKeys, string(value))
+		case 2:
+			hashKeys = append(hashKeys, string(value))
+		case 3:
+			records = append(records, value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	userRecords := make([]UserRecord, 0, len(records))
+	for _, record := range records {
+		var userRecord UserRecord
+		err := walkProtobuf(record, func(field uint64, value []byte, number uint64) error {
+			switch field {
+			case 1:
+				if number >= uint64(len(partitionKeys)) {
+					return errMalformedProtobuf
+				}
+				userRecord.PartitionKey = partitionKeys[number]
+			case 2:
+				if number >= uint64(len(hashKeys)) {
+					return errMalformedProtobuf
+				}
+				userRecord.ExplicitHashKey = hashKeys[number]
+			case 3:
+				userRecord.Data = value
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		userRecords = append(userRecords, userRecord)
+	}
+
+	return userRecords, nil
+}
+
+// walkProtobuf calls fn for every field of the message with the bytes of length-delimited fields or the number of
+// varint fields. Fixed size fields are skipped
+func walkProtobuf(message []byte, fn func(field uint64, value []byte, number uint64) error) error {
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return errMalformedProtobuf
+		}
+		message = message[n:]
+
+		field := key >> 3
+		switch key & 7 {
+		case 0: // varint
+			number, n := binary.Uvarint(message)
+			if n <= 0 {
+				return errMalformedProtobuf
+			}
+			message = message[n:]
+			if err := fn(field, nil, number); err != nil {
+				return err
+			}
+		case 1: // 64-bit
+			if len(message) < 8 {
+				return errMalformedProtobuf
+			}
+			message = message[8:]
+		case 2: // length-delimited
+			size, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < size {
+				return errMalformedProtobuf
+			}
+			value := message[n : n+int(size)]
+			message = message[n+int(size):]
+			if err := fn(field, value, 0); err != nil {
+				return err
+			}
+		case 5: // 32-bit
+			if len(message) < 4 {
+				return errMalformedProtobuf
+			}
+			message = message[4:]
+		default:
+			return errMalformedProtobuf
+		}
+	}
+
+	return nil
+}
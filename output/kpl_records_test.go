@@ -0,0 +1,103 @@
+package output
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+func appendBytesField(b []byte, field uint64, value []byte) []byte {
+	b = binary.AppendUvarint(b, field<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendVarintField(b []byte, field uint64, value uint64) []byte {
+	b = binary.AppendUvarint(b, field<<3)
+	return binary.AppendUvarint(b, value)
+}
+
+// aggregate packs records the way KPL does. Every record has its own partition key and a hash key if it's set
+func aggregate(records []UserRecord) []byte {
+	var message []byte
+	for _, r := range records {
+		message = appendBytesField(message, 1, []byte(r.PartitionKey))
+	}
+	hashKeys := 0
+	for _, r := range records {
+		if r.ExplicitHashKey != "" {
+			message = appendBytesField(message, 2, []byte(r.ExplicitHashKey))
+		}
+	}
+	for i, r := range records {
+		var record []byte
+		record = appendVarintField(record, 1, uint64(i))
+		if r.ExplicitHashKey != "" {
+			record = appendVarintField(record, 2, uint64(hashKeys))
+			hashKeys++
+		}
+		record = appendBytesField(record, 3, r.Data)
+		record = appendBytesField(record, 4, appendBytesField(nil, 1, []byte("tag")))
+		message = appendBytesField(message, 3, record)
+	}
+
+	checksum := md5.Sum(message)
+	data := append(append([]byte{}, kplMagic...), message...)
+	return append(data, checksum[:]...)
+}
+
+func TestEmitRecords(t *testing.T) {
+	arrivedAt := time.Unix(1540000000, 0)
+	aggregated := aggregate([]UserRecord{
+		{Data: []byte(`{"device_id":1}`), PartitionKey: "1"},
+		{Data: []byte(`{"device_id":2}`), PartitionKey: "2", ExplicitHashKey: "42"},
+	})
+	corrupted := append([]byte{}, aggregated...)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+		want [][]byte
+	}{
+		{"plain record", []byte(`{"device_id":0}`), [][]byte{[]byte(`{"device_id":0}`)}},
+		{"empty record", []byte{}, [][]byte{{}}},
+		{"aggregated record", aggregated, [][]byte{[]byte(`{"device_id":1}`), []byte(`{"device_id":2}`)}},
+		{"wrong checksum", corrupted, [][]byte{corrupted}},
+		{"magic only", kplMagic, [][]byte{kplMagic}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]byte
+			emitRecords([]*kinesis.Record{{Data: tt.data, ApproximateArrivalTimestamp: &arrivedAt}}, func(r StreamRecord) {
+				if !r.ArrivedAt.Equal(arrivedAt) {
+					t.Errorf("arrival time is %s, want %s", r.ArrivedAt, arrivedAt)
+				}
+				got = append(got, r.Data)
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got records %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeaggregateKeys(t *testing.T) {
+	want := []UserRecord{
+		{Data: []byte("a"), PartitionKey: "org/1"},
+		{Data: []byte("b"), PartitionKey: "org/2", ExplicitHashKey: "170141183460469231731687303715884105728"},
+	}
+
+	got, ok := Deaggregate(aggregate(want))
+	if !ok {
+		t.Fatal("aggregated record isn't recognized")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
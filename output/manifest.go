@@ -0,0 +1,162 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type ResourceType string
+
+const (
+	KinesisStreamResource ResourceType = "kinesis_stream"
+	FileResource          ResourceType = "file"
+)
+
+type ManifestAction string
+
+const (
+	// ResourceCreating is written right before a resource is created, so it's removed even if the process dies
+	// before the resource is recorded as created
+	ResourceCreating  ManifestAction = "creating"
+	ResourceCreated   ManifestAction = "created"
+	ResourceAbandoned ManifestAction = "abandoned"
+	ResourceRemoved   ManifestAction = "removed"
+)
+
+// Resource is something created by the generator which has to be removed eventually
+type Resource struct {
+	Type ResourceType `json:"type"`
+	Name string       `json:"name"`
+	// Output is the output which created the resource
+	Output string `json:"output"`
+	// Endpoint is the Kinesis endpoint or the output directory of files
+	Endpoint string `json:"endpoint"`
+	Region   string `json:"region,omitempty"`
+}
+
+type ManifestEntry struct {
+	Resource
+	Action    ManifestAction `json:"action"`
+	RunId     string         `json:"run_id"`
+	Timestamp int64          `json:"timestamp"`
+}
+
+// Manifest is an append only log of resources created and removed by the generator.
+// Every entry is synced to the disk, so the manifest survives crashes of the process. Nil manifest records nothing
+type Manifest struct {
+	lock  sync.Mutex
+	file  *os.File
+	runId string
+}
+
+func OpenManifest(fileName string, runId string) (*Manifest, error) {
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("can't open manifest %s: %s", fileName, err)
+	}
+
+	return &Manifest{
+		file:  f,
+		runId: runId,
+	}, nil
+}
+
+func (m *Manifest) Creating(resource Resource) error {
+	return m.record(resource, ResourceCreating)
+}
+
+func (m *Manifest) Created(resource Resource) error {
+	return m.record(resource, ResourceCreated)
+}
+
+// Abandoned releases a resource which was going to be created but turned out to be created by somebody else
+func (m *Manifest) Abandoned(resource Resource) error {
+	return m.record(resource, ResourceAbandoned)
+}
+
+func (m *Manifest) Removed(resource Resource) error {
+	return m.record(resource, ResourceRemoved)
+}
+
+func (m *Manifest) record(resource Resource, action ManifestAction) error {
+	if m == nil {
+		return nil
+	}
+
+	js, err := json.Marshal(ManifestEntry{
+		Resource:  resource,
+		Action:    action,
+		RunId:     m.runId,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	js = append(js, newLineBytes...)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, err := m.file.Write(js); err != nil {
+		return fmt.Errorf("can't write %s of %s into manifest: %s", action, resource.Name, err)
+	}
+
+	return m.file.Sync()
+}
+
+func (m *Manifest) Close() error {
+	if m == nil {
+		return nil
+	}
+
+	return m.file.Close()
+}
+
+// ReadManifest returns resources which were created but not removed yet, in order of creation
+func ReadManifest(fileName string) ([]Resource, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	live := make(map[Resource]bool)
+	order := make([]Resource, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry ManifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("can't parse manifest %s: %s", fileName, err)
+		}
+
+		switch entry.Action {
+		case ResourceCreating, ResourceCreated:
+			if _, ok := live[entry.Resource]; !ok {
+				order = append(order, entry.Resource)
+			}
+			live[entry.Resource] = true
+		case ResourceAbandoned, ResourceRemoved:
+			live[entry.Resource] = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	resources := make([]Resource, 0, len(order))
+	for _, resource := range order {
+		if live[resource] {
+			resources = append(resources, resource)
+		}
+	}
+
+	return resources, nil
+}
@@ -0,0 +1,87 @@
+package output
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadManifest(t *testing.T) {
+	stream := func(name string) Resource {
+		return Resource{Type: KinesisStreamResource, Name: name, Output: "kinesis", Region: "us-east-1"}
+	}
+	file := Resource{Type: FileResource, Name: "default_heartbeat_message_1", Output: "file", Endpoint: "/tmp"}
+
+	type action struct {
+		resource Resource
+		action   ManifestAction
+	}
+	tests := []struct {
+		name    string
+		actions []action
+		want    []Resource
+	}{
+		{"created", []action{{stream("a"), ResourceCreating}, {stream("a"), ResourceCreated}}, []Resource{stream("a")}},
+		{"creating only", []action{{stream("a"), ResourceCreating}}, []Resource{stream("a")}},
+		{"abandoned", []action{{stream("a"), ResourceCreating}, {stream("a"), ResourceAbandoned}}, []Resource{}},
+		{"removed", []action{{stream("a"), ResourceCreated}, {stream("a"), ResourceRemoved}}, []Resource{}},
+		{"created again after removal", []action{
+			{stream("a"), ResourceCreated}, {stream("b"), ResourceCreated}, {stream("a"), ResourceRemoved},
+			{stream("a"), ResourceCreated},
+		}, []Resource{stream("a"), stream("b")}},
+		{"streams and files", []action{
+			{file, ResourceCreated}, {stream("a"), ResourceCreated}, {stream("b"), ResourceCreated},
+			{stream("b"), ResourceRemoved},
+		}, []Resource{file, stream("a")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "manifest")
+			manifest, err := OpenManifest(fileName, "run")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, a := range tt.actions {
+				if err := manifest.record(a.resource, a.action); err != nil {
+					t.Fatal(err)
+				}
+			}
+			manifest.Close()
+
+			got, err := ReadManifest(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got live resources %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadManifestMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"empty lines", "\n\n", false},
+		{"not json", "created a\n", true},
+		{"truncated entry", `{"type":"file","name":"a"` + "\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "manifest")
+			if err := ioutil.WriteFile(fileName, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ReadManifest(fileName); (err != nil) != tt.wantErr {
+				t.Errorf("ReadManifest() returned %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package output
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/melan/gen-events/events_generator"
+)
+
+type PartitionKeyStrategy string
+
+const (
+	DeviceIdKey  PartitionKeyStrategy = "device_id"
+	OrgDeviceKey PartitionKeyStrategy = "org_device"
+	UUIDKey      PartitionKeyStrategy = "uuid"
+	ConstantKey  PartitionKeyStrategy = "constant"
+	ZipfKey      PartitionKeyStrategy = "zipf"
+	HashRangeKey PartitionKeyStrategy = "hash_range"
+)
+
+var AllPartitionKeyStrategies = []PartitionKeyStrategy{DeviceIdKey, OrgDeviceKey, UUIDKey, ConstantKey, ZipfKey, HashRangeKey}
+
+type PartitionKeyConfig struct {
+	Strategy PartitionKeyStrategy
+	// Count is the number of hot keys for ZipfKey and the number of hash key ranges for HashRangeKey
+	Count int
+	// ZipfS is the skew of ZipfKey, has to be > 1. The bigger it is the hotter is the first key
+	ZipfS    float64
+	Constant string
+}
+
+// maxHashKey is the upper bound of Kinesis hash key space, 2^128
+var maxHashKey = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// explicitHashKeyEvent is implemented by events which want to be routed to a particular shard regardless of the partition key
+type explicitHashKeyEvent interface {
+	ExplicitHashKey() string
+}
+
+// explicitHashKey returns the hash key of the event or of an event it wraps, or an empty string
+func explicitHashKey(e events_generator.Event) string {
+	found := events_generator.FindEvent(e, func(e events_generator.Event) bool {
+		_, ok := e.(explicitHashKeyEvent)
+		return ok
+	})
+	if found == nil {
+		return ""
+	}
+
+	return found.(explicitHashKeyEvent).ExplicitHashKey()
+}
+
+type keyedEvent struct {
+	events_generator.Event
+	partitionKey    string
+	explicitHashKey string
+}
+
+func (e *keyedEvent) PartitionKey() string {
+	return e.partitionKey
+}
+
+func (e *keyedEvent) Unwrap() events_generator.Event {
+	return e.Event
+}
+
+func (e *keyedEvent) ExplicitHashKey() string {
+	return e.explicitHashKey
+}
+
+type partitionKeyPublisher struct {
+	EventsPublisher
+	orgId  string
+	config PartitionKeyConfig
+
+	lock       sync.Mutex
+	zipf       *rand.Zipf
+	hashRanges []string
+}
+
+func (p *partitionKeyPublisher) Publish(events []events_generator.Event) {
+	keyed := make([]events_generator.Event, 0, len(events))
+	for _, e := range events {
+		keyed = append(keyed, p.rekey(e))
+	}
+
+	p.EventsPublisher.Publish(keyed)
+}
+
+func (p *partitionKeyPublisher) rekey(e events_generator.Event) events_generator.Event {
+	switch p.config.Strategy {
+	case OrgDeviceKey:
+		orgId := p.orgId
+		if tenantOrgId, _, ok := events_generator.Tenant(e); ok { // the publisher is shared by many orgs
+			orgId = tenantOrgId
+		}
+		return &keyedEvent{Event: e, partitionKey: orgId + "_" + e.PartitionKey()}
+	case UUIDKey:
+		return &keyedEvent{Event: e, partitionKey: newUUID()}
+	case ConstantKey:
+		return &keyedEvent{Event: e, partitionKey: p.config.Constant}
+	case ZipfKey:
+		p.lock.Lock()
+		key := p.zipf.Uint64()
+		p.lock.Unlock()
+		return &keyedEvent{Event: e, partitionKey: fmt.Sprintf("hot_key_%d", key)}
+	case HashRangeKey:
+		h := fnv.New32a()
+		h.Write([]byte(e.PartitionKey()))
+		hashKey := p.hashRanges[h.Sum32()%uint32(len(p.hashRanges))]
+		return &keyedEvent{Event: e, partitionKey: e.PartitionKey(), explicitHashKey: hashKey}
+	default:
+		return e
+	}
+}
+
+// WithPartitionKeys decorates publishers created by the factory to replace partition keys of events
+// according to the strategy. DeviceIdKey keeps keys provided by the events
+func WithPartitionKeys(factory PublisherFactory, config PartitionKeyConfig) PublisherFactory {
+	if config.Strategy == "" || config.Strategy == DeviceIdKey {
+		return factory
+	}
+
+	if config.Count < 1 {
+		config.Count = 1
+	}
+
+	return func(org *events_generator.Org) EventsPublisher {
+		p := &partitionKeyPublisher{
+			EventsPublisher: factory(org),
+			orgId:           org.OrgId,
+			config:          config,
+		}
+
+		switch config.Strategy {
+		case ZipfKey:
+			r := rand.New(rand.NewSource(time.Now().UnixNano()))
+			p.zipf = rand.NewZipf(r, config.ZipfS, 1, uint64(config.Count-1))
+		case HashRangeKey:
+			p.hashRanges = splitHashKeySpace(config.Count)
+		}
+
+		return p
+	}
+}
+
+// splitHashKeySpace returns the first hash key of each of n equal ranges of the Kinesis hash key space
+func splitHashKeySpace(n int) []string {
+	step := new(big.Int).Div(maxHashKey, big.NewInt(int64(n)))
+	keys := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		keys = append(keys, new(big.Int).Mul(step, big.NewInt(int64(i))).String())
+	}
+
+	return keys
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,38 @@
+package output
+
+import (
+	"math/big"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSplitHashKeySpace(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []string
+	}{
+		{1, []string{"0"}},
+		{2, []string{"0", "170141183460469231731687303715884105728"}},
+		{3, []string{"0", "113427455640312821154458202477256070485", "226854911280625642308916404954512140970"}},
+		{4, []string{"0", "85070591730234615865843651857942052864", "170141183460469231731687303715884105728",
+			"255211775190703847597530955573826158592"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.n), func(t *testing.T) {
+			got := splitHashKeySpace(tt.n)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitHashKeySpace(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+
+			// every key is a valid hash key, below 2^128
+			for _, key := range got {
+				k, ok := new(big.Int).SetString(key, 10)
+				if !ok || k.Sign() < 0 || k.Cmp(maxHashKey) >= 0 {
+					t.Errorf("%s is out of the hash key space", key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package output
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/melan/gen-events/misc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	shardRecordsCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: misc.MetricsPrefix,
+			Name:      "shard_records_count",
+			Help:      "Number of records accepted by a shard of a stream",
+		},
+		[]string{"stream", "shard"})
+
+	shardBytesCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: misc.MetricsPrefix,
+			Name:      "shard_bytes_count",
+			Help:      "Data volume accepted by a shard of a stream",
+		},
+		[]string{"stream", "shard"})
+
+	shardSkewGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: misc.MetricsPrefix,
+			Name:      "shard_skew_ratio",
+			Help:      "Ratio between records of the busiest shard and the average shard of a stream during the last report interval",
+		},
+		[]string{"stream"})
+)
+
+type shardUsage struct {
+	records int64
+	bytes   int64
+}
+
+type streamShardStats struct {
+	shards int64
+	window map[string]*shardUsage
+}
+
+// shardStats collects per-shard usage of every stream between two summaries
+var shardStats = struct {
+	sync.Mutex
+	streams map[string]*streamShardStats
+}{streams: make(map[string]*streamShardStats)}
+
+func registerStreamShards(stream string, shards int64) {
+	shardStats.Lock()
+	defer shardStats.Unlock()
+
+	if s, ok := shardStats.streams[stream]; ok {
+		s.shards = shards
+		return
+	}
+
+	shardStats.streams[stream] = &streamShardStats{
+		shards: shards,
+		window: make(map[string]*shardUsage),
+	}
+}
+
+func recordShardUsage(stream string, shard string, bytes int) {
+	shardRecordsCounter.WithLabelValues(stream, shard).Inc()
+	shardBytesCounter.WithLabelValues(stream, shard).Add(float64(bytes))
+
+	shardStats.Lock()
+	defer shardStats.Unlock()
+
+	s, ok := shardStats.streams[stream]
+	if !ok {
+		s = &streamShardStats{window: make(map[string]*shardUsage)}
+		shardStats.streams[stream] = s
+	}
+
+	usage, ok := s.window[shard]
+	if !ok {
+		usage = &shardUsage{}
+		s.window[shard] = usage
+	}
+	usage.records++
+	usage.bytes += int64(bytes)
+}
+
+// ReportShardStats periodically logs how records were spread between shards of every stream
+// and warns about streams where the busiest shard got skewThreshold times more records than the average one
+func ReportShardStats(ctx context.Context, interval time.Duration, skewThreshold float64) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			summarizeShardStats(skewThreshold)
+		}
+	}
+}
+
+func summarizeShardStats(skewThreshold float64) {
+	shardStats.Lock()
+	windows := make(map[string]*streamShardStats, len(shardStats.streams))
+	for stream, s := range shardStats.streams {
+		windows[stream] = &streamShardStats{shards: s.shards, window: s.window}
+		s.window = make(map[string]*shardUsage)
+	}
+	shardStats.Unlock()
+
+	streams := make([]string, 0, len(windows))
+	for stream := range windows {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+
+	for _, stream := range streams {
+		s := windows[stream]
+		if len(s.window) == 0 {
+			continue
+		}
+
+		var totalRecords, totalBytes, maxRecords int64
+		var hottestShard string
+		for shard, usage := range s.window {
+			totalRecords += usage.records
+			totalBytes += usage.bytes
+			if usage.records > maxRecords {
+				maxRecords = usage.records
+				hottestShard = shard
+			}
+		}
+
+		// shards without records don't report anything, so rely on the known number of shards when possible
+		shards := s.shards
+		if shards < int64(len(s.window)) {
+			shards = int64(len(s.window))
+		}
+		skew := float64(maxRecords) / (float64(totalRecords) / float64(shards))
+		shardSkewGauge.WithLabelValues(stream).Set(skew)
+
+		entry := log.WithFields(log.Fields{
+			"stream":        stream,
+			"records":       totalRecords,
+			"bytes":         totalBytes,
+			"shards":        shards,
+			"active_shards": len(s.window),
+			"hottest_shard": hottestShard,
+			"skew":          skew,
+		})
+		if skew >= skewThreshold {
+			entry.Warnf("stream %s has a hot shard %s: %d of %d records", stream, hottestShard, maxRecords, totalRecords)
+		} else {
+			entry.Infof("stream %s shards usage is balanced", stream)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package output
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxTagsPerRequest = 10
+	maxTagsPerStream  = 50
+)
+
+var kinesisStreamNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// ValidateKinesisStreamName checks the name against Kinesis naming rules
+func ValidateKinesisStreamName(name string) error {
+	if len(name) < 1 || len(name) > 128 {
+		return fmt.Errorf("kinesis stream name %q has to be from 1 to 128 characters long", name)
+	}
+
+	if !kinesisStreamNamePattern.MatchString(name) {
+		return fmt.Errorf("kinesis stream name %q can contain only letters, digits, '_', '-' and '.'", name)
+	}
+
+	return nil
+}
+
+// ValidateFileName checks that the name can be used as a name of a file in the output directory
+func ValidateFileName(name string) error {
+	if len(name) < 1 || len(name) > 255 {
+		return fmt.Errorf("file name %q has to be from 1 to 255 bytes long", name)
+	}
+
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+		return fmt.Errorf("file name %q can't be '.', '..' or contain '/' and NUL characters", name)
+	}
+
+	return nil
+}
+
+// ValidateKinesisTags checks tags against limits of Kinesis
+func ValidateKinesisTags(tags map[string]string) error {
+	if len(tags) > maxTagsPerStream {
+		return fmt.Errorf("kinesis stream can have up to %d tags, got %d", maxTagsPerStream, len(tags))
+	}
+
+	for k, v := range tags {
+		if len(k) < 1 || len(k) > 128 {
+			return fmt.Errorf("kinesis tag key %q has to be from 1 to 128 characters long", k)
+		}
+		if len(v) > 256 {
+			return fmt.Errorf("value of kinesis tag %s has to be up to 256 characters long, got %q", k, v)
+		}
+	}
+
+	return nil
+}
+
+func ToKinesisTags(tags map[string]string) map[string]*string {
+	kinesisTags := make(map[string]*string, len(tags))
+	for k, v := range tags {
+		value := v
+		kinesisTags[k] = &value
+	}
+
+	return kinesisTags
+}
@@ -0,0 +1,140 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+)
+
+// StreamRecord is a record read back from a stream or a file. ArrivedAt is zero if the output doesn't track it
+type StreamRecord struct {
+	Data      []byte
+	ArrivedAt time.Time
+}
+
+// ReadKinesisStream calls fn for every record of every shard of the stream which arrived after since.
+// Shards are read till their end or the latest record
+func ReadKinesisStream(client *kinesis.Kinesis, stream string, since time.Time, fn func(StreamRecord)) error {
+	shards, err := listShards(client, stream)
+	if err != nil {
+		return err
+	}
+
+	for _, shard := range shards {
+		if err := readShard(client, stream, shard, since, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func listShards(client *kinesis.Kinesis, stream string) ([]string, error) {
+	shards := make([]string, 0)
+	input := &kinesis.ListShardsInput{StreamName: &stream}
+	for {
+		res, err := client.ListShards(input)
+		if err != nil {
+			if isThrottled(err) {
+				time.Sleep(1 * time.Second)
+				continue
+			}
+			return nil, fmt.Errorf("can't list shards of %s: %s", stream, err)
+		}
+
+		for _, shard := range res.Shards {
+			shards = append(shards, *shard.ShardId)
+		}
+
+		if res.NextToken == nil {
+			return shards, nil
+		}
+		// the stream name can't be set along with the token
+		input = &kinesis.ListShardsInput{NextToken: res.NextToken}
+	}
+}
+
+func readShard(client *kinesis.Kinesis, stream string, shard string, since time.Time, fn func(StreamRecord)) error {
+	iteratorType := kinesis.ShardIteratorTypeAtTimestamp
+	iteratorRes, err := client.GetShardIterator(&kinesis.GetShardIteratorInput{
+		StreamName:        &stream,
+		ShardId:           &shard,
+		ShardIteratorType: &iteratorType,
+		Timestamp:         &since,
+	})
+	if err != nil {
+		return fmt.Errorf("can't get iterator of shard %s of %s: %s", shard, stream, err)
+	}
+
+	var limit int64 = 10000
+	iterator := iteratorRes.ShardIterator
+	for iterator != nil {
+		res, err := client.GetRecords(&kinesis.GetRecordsInput{ShardIterator: iterator, Limit: &limit})
+		if err != nil {
+			if isThrottled(err) {
+				time.Sleep(1 * time.Second)
+				continue
+			}
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ExpiredIteratorException" {
+				return fmt.Errorf("iterator of shard %s of %s expired", shard, stream)
+			}
+			return fmt.Errorf("can't read shard %s of %s: %s", shard, stream, err)
+		}
+
+		emitRecords(res.Records, fn)
+
+		if len(res.Records) == 0 && res.MillisBehindLatest != nil && *res.MillisBehindLatest == 0 {
+			log.Debugf("reached the end of shard %s of %s", shard, stream)
+			return nil
+		}
+
+		iterator = res.NextShardIterator   // it's nil when a closed shard is read to its end
+		time.Sleep(200 * time.Millisecond) // a shard serves up to 5 reads per second
+	}
+
+	return nil
+}
+
+// emitRecords calls fn for every record. Records aggregated by KPL producers are unpacked, so fn gets every event
+func emitRecords(records []*kinesis.Record, fn func(StreamRecord)) {
+	for _, record := range records {
+		var arrivedAt time.Time
+		if record.ApproximateArrivalTimestamp != nil {
+			arrivedAt = *record.ApproximateArrivalTimestamp
+		}
+
+		userRecords, aggregated := Deaggregate(record.Data)
+		if !aggregated {
+			fn(StreamRecord{Data: record.Data, ArrivedAt: arrivedAt})
+			continue
+		}
+		for _, userRecord := range userRecords {
+			fn(StreamRecord{Data: userRecord.Data, ArrivedAt: arrivedAt})
+		}
+	}
+}
+
+// ReadFile calls fn for every event written by FileEventsPublisher for the stream
+func ReadFile(outputPath string, stream string, fn func(StreamRecord)) error {
+	f, err := os.Open(filepath.Join(outputPath, stream))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() { // empty lines are events with empty payloads
+		data := make([]byte, len(scanner.Bytes()))
+		copy(data, scanner.Bytes())
+		fn(StreamRecord{Data: data})
+	}
+
+	return scanner.Err()
+}
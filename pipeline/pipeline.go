@@ -51,7 +51,25 @@ type Pipeline struct {
 	OrgId     string
 	publisher output.EventsPublisher
 	org       *events_generator.Org
-	interval  time.Duration
+
+	lock     sync.Mutex
+	interval time.Duration
+	paused   bool
+	changed  chan struct{}
+	// progress is when the last cycle completed or the pipeline was created, resumed or rescheduled
+	progress time.Time
+	taps     map[chan []events_generator.Event]bool
+}
+
+// Status is a snapshot of the pipeline settings
+type Status struct {
+	OrgId       string                   `json:"org_id"`
+	CaseId      events_generator.Case    `json:"case"`
+	OrgSize     events_generator.OrgSize `json:"org_size"`
+	Stream      string                   `json:"stream"`
+	Devices     int                      `json:"devices"`
+	Paused      bool                     `json:"paused"`
+	IntervalSec float64                  `json:"interval_sec"`
 }
 
 func NewPipeline(publisher output.EventsPublisher, org *events_generator.Org, interval time.Duration) *Pipeline {
@@ -60,6 +78,103 @@ func NewPipeline(publisher output.EventsPublisher, org *events_generator.Org, in
 		publisher: publisher,
 		org:       org,
 		interval:  interval,
+		changed:   make(chan struct{}, 1),
+		progress:  time.Now(),
+		taps:      make(map[chan []events_generator.Event]bool),
+	}
+}
+
+func (p *Pipeline) Org() *events_generator.Org {
+	return p.org
+}
+
+// Pause stops generation of events until the pipeline is resumed. State of devices is kept
+func (p *Pipeline) Pause() {
+	p.lock.Lock()
+	p.paused = true
+	p.lock.Unlock()
+	p.notify()
+}
+
+func (p *Pipeline) Resume() {
+	p.lock.Lock()
+	p.paused = false
+	p.progress = time.Now()
+	p.lock.Unlock()
+	p.notify()
+}
+
+// SetInterval changes the interval between cycles. The next cycle is rescheduled from the start of the previous one
+func (p *Pipeline) SetInterval(interval time.Duration) {
+	p.lock.Lock()
+	p.interval = interval
+	p.progress = time.Now()
+	p.lock.Unlock()
+	p.notify()
+}
+
+func (p *Pipeline) Status() Status {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return Status{
+		OrgId:       p.org.OrgId,
+		CaseId:      p.org.CaseId,
+		OrgSize:     p.org.OrgSize,
+		Stream:      p.org.StreamName(),
+		Devices:     len(p.org.Devices),
+		Paused:      p.paused,
+		IntervalSec: p.interval.Seconds(),
+	}
+}
+
+// Tap returns a channel which gets events of every cycle till the returned function is called.
+// Cycles are skipped for the tap when it isn't read fast enough
+func (p *Pipeline) Tap() (<-chan []events_generator.Event, func()) {
+	tap := make(chan []events_generator.Event, 1)
+
+	p.lock.Lock()
+	p.taps[tap] = true
+	p.lock.Unlock()
+
+	return tap, func() {
+		p.lock.Lock()
+		delete(p.taps, tap)
+		p.lock.Unlock()
+	}
+}
+
+func (p *Pipeline) sendToTaps(events []events_generator.Event) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for tap := range p.taps {
+		select {
+		case tap <- events:
+		default:
+		}
+	}
+}
+
+// Stalled reports if the pipeline is running but hasn't completed a cycle for the number of intervals
+func (p *Pipeline) Stalled(intervals int) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return !p.paused && time.Since(p.progress) > time.Duration(intervals)*p.interval
+}
+
+func (p *Pipeline) settings() (time.Duration, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.interval, p.paused
+}
+
+func (p *Pipeline) notify() {
+	select {
+	case p.changed <- struct{}{}:
+	default: // the pump is going to pick up the settings already
 	}
 }
 
@@ -69,31 +184,43 @@ func (p *Pipeline) Pump(ctx context.Context) {
 	labels["caseId"] = string(p.org.CaseId)
 	labels["orgId"] = p.org.OrgId
 
+	var lastCycle time.Time // zero time makes the first cycle start right away
 	for {
+		interval, _ := p.settings()
+		timer := time.NewTimer(time.Until(lastCycle.Add(interval)))
+
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			log.Printf("Pipeline for org %s of case %s is over. Exiting", p.org.OrgId, string(p.org.CaseId))
 			return
-		default:
-			go func() {
-				start := time.Now().UnixNano()
-				events := p.org.GenerateEvents()
-				end := time.Now().UnixNano()
-				generateTimer.With(labels).Observe(float64(end-start) / 1000)
-
-				start = time.Now().UnixNano()
-				p.publisher.Publish(events)
-				end = time.Now().UnixNano()
-				publishTimer.With(labels).Observe(float64(end-start) / 1000)
-
-				eventsCountGauge.With(labels).Set(float64(len(events)))
-				cyclesCounter.With(labels).Add(1)
-			}()
-			time.Sleep(p.interval)
+		case <-p.changed:
+			timer.Stop()
+		case <-timer.C:
+			lastCycle = time.Now()
+			if _, paused := p.settings(); !paused {
+				go p.cycle(labels)
+			}
 		}
 	}
 }
 
-func (p *Pipeline) Cleanup(g *sync.WaitGroup) {
-	p.publisher.Cleanup(g)
+func (p *Pipeline) cycle(labels prometheus.Labels) {
+	start := time.Now().UnixNano()
+	events := p.org.GenerateEvents()
+	end := time.Now().UnixNano()
+	generateTimer.With(labels).Observe(float64(end-start) / 1000)
+	p.sendToTaps(events)
+
+	start = time.Now().UnixNano()
+	p.publisher.Publish(events)
+	end = time.Now().UnixNano()
+	publishTimer.With(labels).Observe(float64(end-start) / 1000)
+
+	eventsCountGauge.With(labels).Set(float64(len(events)))
+	cyclesCounter.With(labels).Add(1)
+
+	p.lock.Lock()
+	p.progress = time.Now()
+	p.lock.Unlock()
 }